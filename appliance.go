@@ -0,0 +1,121 @@
+package laundryNotify
+
+import (
+	"strings"
+	"time"
+)
+
+// How long a cycle can plausibly run for, unless configured for the appliance
+const DEFAULT_MAX_CYCLE = 4 * time.Hour
+
+// Appliance represents a machine that can be subscribed to
+type Appliance struct {
+	// Identifier for the appliance, stored as the type of events and user events
+	Id string
+	// Name displayed to users
+	Name string
+	// Leaf mqtt topic that events for this appliance are published on
+	Topic string
+	// How long after a cycle finishes to remind users that haven't collected their laundry
+	Reminders []time.Duration
+	// The longest a cycle can plausibly run for. Events open for longer are assumed to have missed their finish
+	MaxCycle time.Duration
+	// Id of the appliance loads usually move on to, eg the dryer after the washer. Users can follow their
+	// load on to it. Empty if there isn't one
+	FollowOn string
+}
+
+func (a *Appliance) Validate() error {
+	if a.Id == "" {
+		return Errorf(EINVALID, "Appliance id required.")
+	}
+
+	if strings.ContainsAny(a.Id, " /") {
+		return Errorf(EINVALID, "Appliance id must not contain spaces or slashes: %s", a.Id)
+	}
+
+	if a.Name == "" {
+		return Errorf(EINVALID, "Appliance name required.")
+	}
+
+	if a.Topic == "" {
+		return Errorf(EINVALID, "Appliance topic required.")
+	}
+
+	if a.MaxCycle <= 0 {
+		return Errorf(EINVALID, "Appliance max cycle must be positive: %s", a.Id)
+	}
+
+	for _, reminder := range a.Reminders {
+		if reminder <= 0 {
+			return Errorf(EINVALID, "Appliance reminders must be positive: %s", a.Id)
+		}
+	}
+
+	return nil
+}
+
+// Appliances is the list of configured appliances, in display order
+type Appliances []*Appliance
+
+// DefaultAppliances returns the appliances used when none are configured
+func DefaultAppliances() Appliances {
+	return Appliances{
+		{Id: "washer", Name: "Washer", Topic: "washer", MaxCycle: DEFAULT_MAX_CYCLE, FollowOn: "dryer"},
+		{Id: "dryer", Name: "Dryer", Topic: "dryer", MaxCycle: DEFAULT_MAX_CYCLE},
+	}
+}
+
+// Find returns the appliance with the given id, or nil if there isn't one
+func (a Appliances) Find(id string) *Appliance {
+	for _, appliance := range a {
+		if appliance.Id == id {
+			return appliance
+		}
+	}
+	return nil
+}
+
+// FindByTopic returns the appliance using the given leaf topic, or nil if there isn't one
+func (a Appliances) FindByTopic(topic string) *Appliance {
+	for _, appliance := range a {
+		if appliance.Topic == topic {
+			return appliance
+		}
+	}
+	return nil
+}
+
+// Validate checks each appliance, and that ids and topics are unique
+func (a Appliances) Validate() error {
+	if len(a) == 0 {
+		return Errorf(EINVALID, "At least one appliance required.")
+	}
+
+	ids, topics := make(map[string]bool), make(map[string]bool)
+	for _, appliance := range a {
+		if err := appliance.Validate(); err != nil {
+			return err
+		}
+		if ids[appliance.Id] {
+			return Errorf(EINVALID, "Duplicate appliance id: %s", appliance.Id)
+		}
+		if topics[appliance.Topic] {
+			return Errorf(EINVALID, "Duplicate appliance topic: %s", appliance.Topic)
+		}
+		ids[appliance.Id], topics[appliance.Topic] = true, true
+	}
+
+	for _, appliance := range a {
+		if appliance.FollowOn == "" {
+			continue
+		}
+		if appliance.FollowOn == appliance.Id {
+			return Errorf(EINVALID, "Appliance can't follow on to itself: %s", appliance.Id)
+		}
+		if a.Find(appliance.FollowOn) == nil {
+			return Errorf(EINVALID, "Appliance follows on to an unknown appliance: %s", appliance.FollowOn)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,29 @@
+package laundryNotify
+
+import (
+	"context"
+	"time"
+)
+
+// Minimum length of a user's PIN or passphrase
+const MIN_PIN_LENGTH = 4
+
+// How long a sign in link can be used for
+const LOGIN_TOKEN_LIFETIME = 15 * time.Minute
+
+// AuthService manages how users prove who they are. Users are unprotected until they set a PIN, and
+// anyone can act on their behalf.
+type AuthService interface {
+	// SetPin sets the user's PIN or passphrase. An empty PIN removes it, so the user is unprotected again.
+	// Either way the user's credential version changes, signing out their existing sessions
+	SetPin(ctx context.Context, userId int, pin string) error
+	// AuthenticatePin returns EUNAUTHORIZED if the PIN doesn't match the user's
+	AuthenticatePin(ctx context.Context, userId int, pin string) error
+	// CredentialVersion returns a number that changes whenever the user's PIN does. Sessions are only
+	// valid for the version they were signed in with
+	CredentialVersion(ctx context.Context, userId int) (int, error)
+	// CreateLoginToken returns a single use token the user can sign in with for LOGIN_TOKEN_LIFETIME
+	CreateLoginToken(ctx context.Context, userId int) (string, error)
+	// RedeemLoginToken returns the id of the user the token was created for, and stops it being used again
+	RedeemLoginToken(ctx context.Context, token string) (int, error)
+}
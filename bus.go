@@ -0,0 +1,24 @@
+package laundryNotify
+
+const EVENT_STARTED = "started"
+const EVENT_FINISHED = "finished"
+
+// ApplianceUpdate is published whenever an event for an appliance starts or finishes
+type ApplianceUpdate struct {
+	// Either EVENT_STARTED or EVENT_FINISHED
+	Kind  string
+	Event *Event
+}
+
+// EventBus distributes appliance updates within the process, eg to live status pages
+type EventBus interface {
+	// Publish sends the update to every current subscriber. It must not block
+	Publish(update ApplianceUpdate)
+	Subscribe() Subscription
+}
+
+// Subscription receives updates published to an EventBus until it is closed
+type Subscription interface {
+	C() <-chan ApplianceUpdate
+	Close()
+}
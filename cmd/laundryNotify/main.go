@@ -1,13 +1,21 @@
 package main
 
 import (
+	"fmt"
+	laundryNotify "jallier/laundry-notify"
 	"jallier/laundry-notify/internal/http"
+	"jallier/laundry-notify/internal/inmem"
 	"jallier/laundry-notify/internal/mqtt"
+	"jallier/laundry-notify/internal/notify"
 	"jallier/laundry-notify/internal/ntfy"
 	"jallier/laundry-notify/internal/sqlite"
+	"net/url"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
+	"time"
 
 	"github.com/charmbracelet/log"
 	"github.com/joho/godotenv"
@@ -63,30 +71,58 @@ type Main struct {
 	Ntfy                     *ntfy.NtfyManager
 	Http                     *http.HttpServer
 	Config                   *Config
+	EventBus                 *inmem.EventBus
+	Dispatcher               *notify.Dispatcher
+	Scheduler                *notify.Scheduler
+	Watchdog                 *notify.Watchdog
 	LaundrySubscriberService *mqtt.LaundrySubscriberService
+	PowerSubscriberService   *mqtt.PowerSubscriberService
+	HomeAssistantPublisher   *mqtt.HomeAssistantPublisher
 }
 
 // Returns a new instance of Main
 func NewMain() *Main {
 	return &Main{
-		DB:     sqlite.NewDB(""),
-		MQTT:   mqtt.NewMQTTManager(),
-		Ntfy:   ntfy.NewNtfyManager("", nil),
-		Http:   http.NewHttpServer(),
-		Config: DefaultConfig(),
+		DB:       sqlite.NewDB(""),
+		MQTT:     mqtt.NewMQTTManager(),
+		Ntfy:     ntfy.NewNtfyManager("", nil),
+		Http:     http.NewHttpServer(),
+		Config:   DefaultConfig(),
+		EventBus: inmem.NewEventBus(),
 	}
 }
 
 // Close gracefully shuts down the application
 func (m *Main) Close() error {
+	if m.HomeAssistantPublisher != nil {
+		m.HomeAssistantPublisher.Close()
+	}
+
+	if m.Watchdog != nil {
+		m.Watchdog.Close()
+	}
+
+	if m.Scheduler != nil {
+		m.Scheduler.Close()
+	}
+
+	if m.Dispatcher != nil {
+		m.Dispatcher.Close()
+	}
+
+	// Disconnect first, so queued messages are handled before the db is closed
+	if m.MQTT != nil {
+		m.MQTT.Disconnect()
+	}
+
 	if m.DB != nil {
 		if err := m.DB.Close(); err != nil {
 			return err
 		}
 	}
 
-	if m.MQTT != nil {
-		m.MQTT.Disconnect()
+	if m.EventBus != nil {
+		m.EventBus.Close()
 	}
 
 	return nil
@@ -108,62 +144,198 @@ func (m *Main) Run(ctx context.Context) (err error) {
 	mqttOpts.SetClientID(m.Config.MQTT.ClientId)
 	mqttOpts.SetUsername(m.Config.MQTT.Username)
 	mqttOpts.SetPassword(m.Config.MQTT.Password)
+	mqttOpts.SetCleanSession(m.Config.MQTT.CleanSession)
+	if m.Config.MQTT.CAFile != "" || m.Config.MQTT.CertFile != "" || m.Config.MQTT.InsecureSkipVerify {
+		tlsConfig, err := mqtt.NewTLSConfig(
+			m.Config.MQTT.CAFile,
+			m.Config.MQTT.CertFile,
+			m.Config.MQTT.KeyFile,
+			m.Config.MQTT.InsecureSkipVerify,
+		)
+		if err != nil {
+			log.Error("failed to set up mqtt tls", "error", err)
+			return err
+		}
+		mqttOpts.SetTLSConfig(tlsConfig)
+	}
 
-	// Ensure that the subscription is re-established when the connection is lost
+	if m.Config.MQTT.StoreDir != "" {
+		mqttOpts.SetStore(mqtt.NewFileStore(m.Config.MQTT.StoreDir))
+	}
+
+	// Subscriptions are re-established by the manager, so only discovery needs doing on each connect
 	mqttOpts.SetOnConnectHandler(func(_ mqtt.Client) {
 		log.Debug("connection to mqtt broker established")
-		if m.LaundrySubscriberService != nil {
-			m.LaundrySubscriberService.Subscribe(m.Config.MQTT.topic)
-		} else {
-			log.Error("laundry subscriber service is nil")
+		if m.HomeAssistantPublisher != nil {
+			if err := m.HomeAssistantPublisher.PublishDiscovery(); err != nil {
+				log.Error("failed to publish home assistant discovery", "error", err)
+			}
 		}
 	})
 
-	if m.Config.Ntfy.NtfyServer == "" {
-		m.Config.Ntfy.NtfyServer = "https://ntfy.sh"
-	}
-	m.Ntfy.NtfyServer = m.Config.Ntfy.NtfyServer
-	m.Ntfy.BaseTopic = m.Config.Ntfy.BaseTopic
-	err = m.Ntfy.Connect()
+	notifiers, err := m.newNotifierRegistry()
 	if err != nil {
-		log.Error("failed to connect to ntfy server", "error", err)
+		log.Error("failed to set up notifiers", "error", err)
 		return err
 	}
 
 	m.Http.Config.Env = m.Config.Http.Env
-	m.Http.Config.NtfyBaseTopic = m.Config.Ntfy.BaseTopic
+	if notifiers.Get(NotifierNtfy) != nil {
+		m.Http.Config.NtfyBaseTopic = m.Config.Ntfy.BaseTopic
+	}
+	m.Http.Appliances = m.Config.Appliances
+	m.Http.Config.Notifiers = m.Config.Notifiers
+	m.Http.Config.WebhookHosts = m.Config.Webhook.AllowedHosts
+	m.Http.Config.SessionSecret = []byte(m.Config.Http.SessionSecret)
+	m.Http.Config.BaseURL = m.Config.Http.BaseURL
 	m.Http.Open()
 
 	// Set up the services using the root dependencies
 	userService := sqlite.NewUserService(m.DB)
 	eventService := sqlite.NewEventService(m.DB)
 	userEventService := sqlite.NewUserEventService(m.DB)
+	reminderService := sqlite.NewReminderService(m.DB)
+	preferencesService := sqlite.NewPreferencesService(m.DB)
+	standingSubscriptionService := sqlite.NewStandingSubscriptionService(m.DB)
 
 	m.Http.UserService = userService
 	m.Http.EventService = eventService
 	m.Http.UserEventService = userEventService
+	m.Http.ReminderService = reminderService
+	m.Http.PreferencesService = preferencesService
+	m.Http.StandingSubscriptionService = standingSubscriptionService
+	m.Http.EventBus = m.EventBus
+	m.Http.MQTTStatusService = m.MQTT
 
-	ntfyService := ntfy.NewLaundryNotifyService(m.Ntfy)
+	notificationService := sqlite.NewNotificationService(m.DB)
+	m.Http.AuthService = sqlite.NewAuthService(m.DB)
+	m.Http.NotificationService = notificationService
+	m.Dispatcher = notify.NewDispatcher(notificationService, userService, preferencesService, notifiers)
+	m.Dispatcher.Open()
+
+	m.Scheduler = notify.NewScheduler(reminderService, eventService, m.Config.Appliances)
+	m.Scheduler.AlmostDone = m.Config.AlmostDone
+	m.Scheduler.Open()
 
 	m.LaundrySubscriberService = mqtt.NewLaundrySubscriberService(
 		m.MQTT,
+		m.Config.Appliances,
 		eventService,
 		userEventService,
-		ntfyService,
+		sqlite.NewIngestionService(m.DB),
+		m.EventBus,
+	)
+	m.PowerSubscriberService = mqtt.NewPowerSubscriberService(
+		m.MQTT,
+		m.LaundrySubscriberService,
+		mqtt.PowerThresholds{
+			Start:    m.Config.Power.StartThreshold,
+			Stop:     m.Config.Power.StopThreshold,
+			Debounce: m.Config.Power.Debounce,
+		},
 	)
 
+	if m.Config.HomeAssistant.Enabled {
+		m.HomeAssistantPublisher = mqtt.NewHomeAssistantPublisher(
+			m.MQTT,
+			m.Config.Appliances,
+			eventService,
+			userEventService,
+			m.EventBus,
+		)
+		m.HomeAssistantPublisher.DiscoveryPrefix = m.Config.HomeAssistant.DiscoveryPrefix
+		m.HomeAssistantPublisher.StateTopic = m.Config.HomeAssistant.StateTopic
+		m.HomeAssistantPublisher.Open()
+	}
+
+	if err := m.LaundrySubscriberService.Reconcile(); err != nil {
+		log.Error("failed to reconcile open events", "error", err)
+		return err
+	}
+	if m.Config.MQTT.topic != "" {
+		m.LaundrySubscriberService.Subscribe(m.Config.MQTT.topic)
+	}
+	for eventType, topic := range m.Config.Power.Topics {
+		m.PowerSubscriberService.Subscribe(topic, eventType)
+	}
+
 	m.MQTT.MqttOpts = mqttOpts
+	m.MQTT.AvailabilityTopic = m.Config.MQTT.AvailabilityTopic
+	m.MQTT.QoS = m.Config.MQTT.QoS
+	m.MQTT.Queue.Size = m.Config.MQTT.QueueSize
+	m.MQTT.Queue.Policy = m.Config.MQTT.QueuePolicy
 	_, err = m.MQTT.Connect()
 	if err != nil {
 		log.Error("failed to connect to mqtt broker", "error", err)
 		return err
 	}
 
+	// The watchdog only starts once connected, and waits for the broker to replay the messages it kept
+	// while we were down, so it doesn't time out loads whose finish is about to arrive
+	m.Watchdog = notify.NewWatchdog(eventService, notificationService, m.EventBus, m.Config.Appliances)
+	m.Watchdog.AdminTopic = m.Config.AdminTopic
+	m.Watchdog.Open()
+
 	return nil
 }
 
+// newNotifierRegistry sets up each notifier backend enabled in the config
+func (m *Main) newNotifierRegistry() (*notify.Registry, error) {
+	registry := notify.NewRegistry()
+	for _, name := range m.Config.Notifiers {
+		switch name {
+		case NotifierNtfy:
+			if m.Config.Ntfy.NtfyServer == "" {
+				m.Config.Ntfy.NtfyServer = "https://ntfy.sh"
+			}
+			m.Ntfy.NtfyServer = m.Config.Ntfy.NtfyServer
+			m.Ntfy.BaseTopic = m.Config.Ntfy.BaseTopic
+			if err := m.Ntfy.Connect(); err != nil {
+				return nil, fmt.Errorf("failed to connect to ntfy server: %w", err)
+			}
+			registry.Register(name, ntfy.NewLaundryNotifyService(m.Ntfy))
+		case NotifierWebhook:
+			webhook := notify.NewWebhookNotifier(m.Config.Webhook.URL, nil)
+			webhook.AllowedHosts = m.Config.Webhook.AllowedHosts
+			registry.Register(name, webhook)
+		case NotifierEmail:
+			registry.Register(name, notify.NewEmailNotifier(
+				m.Config.Smtp.Host,
+				m.Config.Smtp.Port,
+				m.Config.Smtp.Username,
+				m.Config.Smtp.Password,
+				m.Config.Smtp.From,
+				m.Config.Smtp.To,
+			))
+		case NotifierGotify:
+			registry.Register(name, notify.NewGotifyNotifier(m.Config.Gotify.Server, m.Config.Gotify.Token, nil))
+		case NotifierApprise:
+			registry.Register(name, notify.NewAppriseNotifier(m.Config.Apprise.Server, m.Config.Apprise.Key, nil))
+		default:
+			return nil, fmt.Errorf("unknown notifier: %s", name)
+		}
+		log.Debug("notifier enabled", "notifier", name)
+	}
+	return registry, nil
+}
+
 const DefaultDSN = "data.db"
 
+// By default the broker keeps a persistent session for MQTT_CLIENT_ID, so messages sent while the service
+// is down are delivered once it's back
+const DefaultMQTTQoS = 1
+const DefaultMQTTStoreDir = "data/mqtt"
+const DefaultSmtpPort = 587
+
+const NotifierNtfy = "ntfy"
+const NotifierWebhook = "webhook"
+const NotifierEmail = "email"
+const NotifierGotify = "gotify"
+const NotifierApprise = "apprise"
+const DefaultPowerStartThreshold = 10
+const DefaultPowerStopThreshold = 5
+const DefaultPowerDebounce = 2 * time.Minute
+
 // Config represents the application configuration
 type Config struct {
 	MQTT struct {
@@ -172,24 +344,100 @@ type Config struct {
 		Username string
 		Password string
 		topic    string
+		// Where online/offline is published, retained
+		AvailabilityTopic string
+		// QoS used for subscriptions
+		QoS byte
+		// Whether the broker should discard our subscriptions and queued messages when we disconnect
+		CleanSession bool
+		// Where in flight messages are kept, so they survive a restart. Kept in memory if empty
+		StoreDir string
+		// CA bundle used to verify the broker, in addition to the system roots
+		CAFile string
+		// Client certificate and key, for mutual TLS
+		CertFile           string
+		KeyFile            string
+		InsecureSkipVerify bool
+		// Number of messages buffered for each topic, and what to do when that fills up
+		QueueSize   int
+		QueuePolicy string
+	}
+	// Power readings from smart plugs, used to detect cycles directly
+	Power struct {
+		// Event type -> power topic
+		Topics         map[string]string
+		StartThreshold float64
+		StopThreshold  float64
+		Debounce       time.Duration
+	}
+	// Home Assistant MQTT discovery and appliance state publishing
+	HomeAssistant struct {
+		Enabled         bool
+		DiscoveryPrefix string
+		StateTopic      string
 	}
 	DB struct {
 		DSN string
 	}
-	Ntfy struct {
+	// Enabled notifier backends. The first is the default
+	Notifiers []string
+	Ntfy      struct {
 		NtfyServer string
 		BaseTopic  string
 	}
+	Webhook struct {
+		URL string
+		// Hosts users can set their webhook url to. Defaults to the host of URL
+		AllowedHosts []string
+	}
+	Smtp struct {
+		Host     string
+		Port     int
+		Username string
+		Password string
+		From     string
+		To       string
+	}
+	Gotify struct {
+		Server string
+		Token  string
+	}
+	Apprise struct {
+		Server string
+		Key    string
+	}
 	Http struct {
 		Env string
+		// Key used to sign session cookies
+		SessionSecret string
+		// Public url of the site, used in sign in links
+		BaseURL string
 	}
-	Env string
+	Appliances laundryNotify.Appliances
+	// How long before the estimated finish to send an almost done notification. Zero disables it
+	AlmostDone time.Duration
+	// Where alerts about the service itself are sent, eg when a cycle times out
+	AdminTopic string
+	Env        string
 }
 
 // DefaultConfig returns a new instance of Config with default values
 func DefaultConfig() *Config {
 	var config Config
 	config.DB.DSN = DefaultDSN
+	config.MQTT.QoS = DefaultMQTTQoS
+	config.MQTT.CleanSession = false
+	config.MQTT.StoreDir = DefaultMQTTStoreDir
+	config.MQTT.QueueSize = mqtt.DefaultQueueSize
+	config.MQTT.QueuePolicy = mqtt.QUEUE_BLOCK
+	config.Notifiers = []string{NotifierNtfy}
+	config.Smtp.Port = DefaultSmtpPort
+	config.Appliances = laundryNotify.DefaultAppliances()
+	config.Power.StartThreshold = DefaultPowerStartThreshold
+	config.Power.StopThreshold = DefaultPowerStopThreshold
+	config.Power.Debounce = DefaultPowerDebounce
+	config.HomeAssistant.DiscoveryPrefix = mqtt.DefaultDiscoveryPrefix
+	config.HomeAssistant.StateTopic = mqtt.DefaultStateTopic
 
 	return &config
 }
@@ -205,19 +453,322 @@ func SetConfigFromEnv(config *Config) {
 		log.Fatal("MQTT_URL is required")
 	}
 	config.MQTT.ClientId = os.Getenv("MQTT_CLIENT_ID")
-	if config.MQTT.ClientId == "" {
-		log.Fatal("MQTT_CLIENT_ID is required")
-	}
 	config.MQTT.Username = os.Getenv("MQTT_USERNAME")
 	config.MQTT.Password = os.Getenv("MQTT_PASSWORD")
 	config.MQTT.topic = os.Getenv("MQTT_TOPIC")
-	if config.MQTT.topic == "" {
-		log.Fatal("MQTT_TOPIC is required")
+	if v := os.Getenv("MQTT_QOS"); v != "" {
+		qos, err := strconv.Atoi(v)
+		if err != nil || qos < 0 || qos > 2 {
+			log.Fatal("MQTT_QOS must be 0, 1 or 2")
+		}
+		config.MQTT.QoS = byte(qos)
+	}
+	if v := os.Getenv("MQTT_CLEAN_SESSION"); v != "" {
+		cleanSession, err := strconv.ParseBool(v)
+		if err != nil {
+			log.Fatal("MQTT_CLEAN_SESSION must be true or false", "error", err)
+		}
+		config.MQTT.CleanSession = cleanSession
+	}
+	if !config.MQTT.CleanSession && config.MQTT.QoS == 0 {
+		log.Fatal("MQTT_CLEAN_SESSION=false needs MQTT_QOS of 1 or 2, as the broker doesn't keep QoS 0 messages")
+	}
+	// The broker hands a persistent session to whichever client connects with its id, so a shared default
+	// would have instances take over each other's session. With a clean session the broker assigns an id
+	if !config.MQTT.CleanSession && config.MQTT.ClientId == "" {
+		log.Fatal("MQTT_CLIENT_ID is required for a persistent session. Set it to an id unique to this instance, or set MQTT_CLEAN_SESSION=true")
+	}
+	// An empty MQTT_STORE_DIR keeps in flight messages in memory
+	if v, ok := os.LookupEnv("MQTT_STORE_DIR"); ok {
+		config.MQTT.StoreDir = v
+	}
+	config.MQTT.CAFile = os.Getenv("MQTT_CA_FILE")
+	config.MQTT.CertFile = os.Getenv("MQTT_CERT_FILE")
+	config.MQTT.KeyFile = os.Getenv("MQTT_KEY_FILE")
+	if (config.MQTT.CertFile == "") != (config.MQTT.KeyFile == "") {
+		log.Fatal("MQTT_CERT_FILE and MQTT_KEY_FILE must be set together")
+	}
+	if v := os.Getenv("MQTT_INSECURE_SKIP_VERIFY"); v != "" {
+		insecureSkipVerify, err := strconv.ParseBool(v)
+		if err != nil {
+			log.Fatal("MQTT_INSECURE_SKIP_VERIFY must be true or false", "error", err)
+		}
+		config.MQTT.InsecureSkipVerify = insecureSkipVerify
+	}
+	if v := os.Getenv("MQTT_QUEUE_SIZE"); v != "" {
+		queueSize, err := strconv.Atoi(v)
+		if err != nil || queueSize < 1 {
+			log.Fatal("MQTT_QUEUE_SIZE must be a positive number")
+		}
+		config.MQTT.QueueSize = queueSize
+	}
+	if v := os.Getenv("MQTT_QUEUE_POLICY"); v != "" {
+		if v != mqtt.QUEUE_BLOCK && v != mqtt.QUEUE_DROP {
+			log.Fatal("MQTT_QUEUE_POLICY must be block or drop")
+		}
+		config.MQTT.QueuePolicy = v
+	}
+	if v := os.Getenv("APPLIANCES"); v != "" {
+		appliances, err := parseAppliances(v)
+		if err != nil {
+			log.Fatal("APPLIANCES is invalid", "error", err)
+		}
+		config.Appliances = appliances
+	}
+	if v := os.Getenv("REMINDERS"); v != "" {
+		if err := parseReminders(v, config.Appliances); err != nil {
+			log.Fatal("REMINDERS is invalid", "error", err)
+		}
+	}
+	if v, ok := os.LookupEnv("FOLLOW_ON"); ok {
+		if err := parseFollowOn(v, config.Appliances); err != nil {
+			log.Fatal("FOLLOW_ON is invalid", "error", err)
+		}
 	}
-	config.Ntfy.NtfyServer = os.Getenv("NTFY_SERVER")
-	config.Ntfy.BaseTopic = os.Getenv("NTFY_BASE_TOPIC")
-	if config.Ntfy.BaseTopic == "" {
-		log.Fatal("NTFY_BASE_TOPIC is required")
+	if v := os.Getenv("MAX_CYCLES"); v != "" {
+		if err := parseMaxCycles(v, config.Appliances); err != nil {
+			log.Fatal("MAX_CYCLES is invalid", "error", err)
+		}
+	}
+	powerTopics, err := parsePowerTopics(os.Getenv("MQTT_POWER_TOPICS"))
+	if err != nil {
+		log.Fatal("MQTT_POWER_TOPICS is invalid", "error", err)
+	}
+	config.Power.Topics = powerTopics
+	for eventType := range config.Power.Topics {
+		if config.Appliances.Find(eventType) == nil {
+			log.Fatal("MQTT_POWER_TOPICS references an unknown appliance", "appliance", eventType)
+		}
+	}
+	if config.MQTT.topic == "" && len(config.Power.Topics) == 0 {
+		log.Fatal("MQTT_TOPIC or MQTT_POWER_TOPICS is required")
+	}
+	if v := os.Getenv("POWER_START_THRESHOLD"); v != "" {
+		if config.Power.StartThreshold, err = strconv.ParseFloat(v, 64); err != nil {
+			log.Fatal("POWER_START_THRESHOLD must be a number", "error", err)
+		}
+	}
+	if v := os.Getenv("POWER_STOP_THRESHOLD"); v != "" {
+		if config.Power.StopThreshold, err = strconv.ParseFloat(v, 64); err != nil {
+			log.Fatal("POWER_STOP_THRESHOLD must be a number", "error", err)
+		}
+	}
+	if config.Power.StopThreshold > config.Power.StartThreshold {
+		log.Fatal("POWER_STOP_THRESHOLD must not be greater than POWER_START_THRESHOLD")
+	}
+	if v := os.Getenv("POWER_DEBOUNCE"); v != "" {
+		if config.Power.Debounce, err = time.ParseDuration(v); err != nil {
+			log.Fatal("POWER_DEBOUNCE must be a duration, eg 2m", "error", err)
+		}
+	}
+	if v := os.Getenv("HA_DISCOVERY"); v != "" {
+		if config.HomeAssistant.Enabled, err = strconv.ParseBool(v); err != nil {
+			log.Fatal("HA_DISCOVERY must be true or false", "error", err)
+		}
+	}
+	if v := os.Getenv("HA_DISCOVERY_PREFIX"); v != "" {
+		config.HomeAssistant.DiscoveryPrefix = v
+	}
+	if v := os.Getenv("HA_STATE_TOPIC"); v != "" {
+		config.HomeAssistant.StateTopic = strings.TrimSuffix(v, "/")
+	}
+	config.MQTT.AvailabilityTopic = os.Getenv("MQTT_AVAILABILITY_TOPIC")
+	if config.MQTT.AvailabilityTopic == "" {
+		config.MQTT.AvailabilityTopic = config.HomeAssistant.StateTopic + "/availability"
+	}
+	if v := os.Getenv("ALMOST_DONE"); v != "" {
+		if config.AlmostDone, err = time.ParseDuration(v); err != nil || config.AlmostDone < 0 {
+			log.Fatal("ALMOST_DONE must be a positive duration, eg 5m", "error", err)
+		}
+	}
+	config.AdminTopic = os.Getenv("ADMIN_TOPIC")
+	if v := os.Getenv("NOTIFIERS"); v != "" {
+		config.Notifiers = nil
+		for _, name := range strings.Split(v, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				config.Notifiers = append(config.Notifiers, name)
+			}
+		}
+	}
+	if len(config.Notifiers) == 0 {
+		log.Fatal("NOTIFIERS must include at least one notifier")
+	}
+	for _, name := range config.Notifiers {
+		switch name {
+		case NotifierNtfy:
+			config.Ntfy.NtfyServer = os.Getenv("NTFY_SERVER")
+			config.Ntfy.BaseTopic = os.Getenv("NTFY_BASE_TOPIC")
+			if config.Ntfy.BaseTopic == "" {
+				log.Fatal("NTFY_BASE_TOPIC is required")
+			}
+		case NotifierWebhook:
+			config.Webhook.URL = os.Getenv("WEBHOOK_URL")
+			if config.Webhook.URL == "" {
+				log.Fatal("WEBHOOK_URL is required")
+			}
+			u, err := url.Parse(config.Webhook.URL)
+			if err != nil || u.Hostname() == "" {
+				log.Fatal("WEBHOOK_URL must be a url", "url", config.Webhook.URL)
+			}
+			config.Webhook.AllowedHosts = []string{u.Hostname()}
+			if v := os.Getenv("WEBHOOK_ALLOWED_HOSTS"); v != "" {
+				config.Webhook.AllowedHosts = nil
+				for _, host := range strings.Split(v, ",") {
+					if host = strings.TrimSpace(host); host != "" {
+						config.Webhook.AllowedHosts = append(config.Webhook.AllowedHosts, host)
+					}
+				}
+			}
+		case NotifierEmail:
+			config.Smtp.Host = os.Getenv("SMTP_HOST")
+			if config.Smtp.Host == "" {
+				log.Fatal("SMTP_HOST is required")
+			}
+			if v := os.Getenv("SMTP_PORT"); v != "" {
+				if config.Smtp.Port, err = strconv.Atoi(v); err != nil {
+					log.Fatal("SMTP_PORT must be a number", "error", err)
+				}
+			}
+			config.Smtp.Username = os.Getenv("SMTP_USERNAME")
+			config.Smtp.Password = os.Getenv("SMTP_PASSWORD")
+			config.Smtp.From = os.Getenv("SMTP_FROM")
+			if config.Smtp.From == "" {
+				log.Fatal("SMTP_FROM is required")
+			}
+			config.Smtp.To = os.Getenv("SMTP_TO")
+		case NotifierGotify:
+			config.Gotify.Server = os.Getenv("GOTIFY_SERVER")
+			config.Gotify.Token = os.Getenv("GOTIFY_TOKEN")
+			if config.Gotify.Server == "" || config.Gotify.Token == "" {
+				log.Fatal("GOTIFY_SERVER and GOTIFY_TOKEN are required")
+			}
+		case NotifierApprise:
+			config.Apprise.Server = os.Getenv("APPRISE_SERVER")
+			config.Apprise.Key = os.Getenv("APPRISE_KEY")
+			if config.Apprise.Server == "" || config.Apprise.Key == "" {
+				log.Fatal("APPRISE_SERVER and APPRISE_KEY are required")
+			}
+		default:
+			log.Fatal("NOTIFIERS contains an unknown notifier", "notifier", name)
+		}
 	}
 	config.Http.Env = config.Env
+	config.Http.SessionSecret = os.Getenv("SESSION_SECRET")
+	config.Http.BaseURL = os.Getenv("BASE_URL")
+}
+
+// parsePowerTopics parses a comma separated list of `type=topic` pairs, eg `washer=tele/washer/SENSOR`
+func parsePowerTopics(value string) (map[string]string, error) {
+	topics := make(map[string]string)
+	if strings.TrimSpace(value) == "" {
+		return topics, nil
+	}
+
+	for _, pair := range strings.Split(value, ",") {
+		eventType, topic, found := strings.Cut(strings.TrimSpace(pair), "=")
+		if !found || eventType == "" || topic == "" {
+			return nil, fmt.Errorf("expected type=topic, got %q", pair)
+		}
+		topics[eventType] = topic
+	}
+	return topics, nil
+}
+
+// parseAppliances parses a comma separated list of `id:name:topic` appliances, eg `washer:Upstairs washer:washer_1`.
+// The name and topic default to the id if omitted.
+func parseAppliances(value string) (laundryNotify.Appliances, error) {
+	var appliances laundryNotify.Appliances
+	for _, definition := range strings.Split(value, ",") {
+		fields := strings.Split(strings.TrimSpace(definition), ":")
+		if len(fields) > 3 {
+			return nil, fmt.Errorf("expected id:name:topic, got %q", definition)
+		}
+		appliance := &laundryNotify.Appliance{Id: fields[0], Name: fields[0], Topic: fields[0], MaxCycle: laundryNotify.DEFAULT_MAX_CYCLE}
+		if len(fields) > 1 && fields[1] != "" {
+			appliance.Name = fields[1]
+		}
+		if len(fields) > 2 && fields[2] != "" {
+			appliance.Topic = fields[2]
+		}
+		appliances = append(appliances, appliance)
+	}
+
+	if err := appliances.Validate(); err != nil {
+		return nil, err
+	}
+	return appliances, nil
+}
+
+// parseReminders parses a comma separated list of `id:duration:duration...` reminders, eg `washer:30m:90m`, and
+// sets them on the matching appliances
+func parseReminders(value string, appliances laundryNotify.Appliances) error {
+	for _, definition := range strings.Split(value, ",") {
+		fields := strings.Split(strings.TrimSpace(definition), ":")
+		appliance := appliances.Find(fields[0])
+		if appliance == nil {
+			return fmt.Errorf("unknown appliance %q", fields[0])
+		}
+
+		appliance.Reminders = nil
+		for _, field := range fields[1:] {
+			d, err := time.ParseDuration(field)
+			if err != nil {
+				return fmt.Errorf("invalid reminder for %s: %w", appliance.Id, err)
+			}
+			appliance.Reminders = append(appliance.Reminders, d)
+		}
+		if err := appliance.Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseMaxCycles parses a comma separated list of `id:duration` pairs, eg `washer:3h`, and sets the max cycle
+// length on the matching appliances
+func parseMaxCycles(value string, appliances laundryNotify.Appliances) error {
+	for _, pair := range strings.Split(value, ",") {
+		id, duration, found := strings.Cut(strings.TrimSpace(pair), ":")
+		if !found {
+			return fmt.Errorf("expected id:duration, got %q", pair)
+		}
+		appliance := appliances.Find(id)
+		if appliance == nil {
+			return fmt.Errorf("unknown appliance %q", id)
+		}
+
+		d, err := time.ParseDuration(duration)
+		if err != nil {
+			return fmt.Errorf("invalid max cycle for %s: %w", appliance.Id, err)
+		}
+		appliance.MaxCycle = d
+		if err := appliance.Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseFollowOn parses a comma separated list of `id:id` pairs, eg `washer:dryer`, and sets the appliance loads
+// move on to. An empty value means loads don't move on from any appliance
+func parseFollowOn(value string, appliances laundryNotify.Appliances) error {
+	for _, appliance := range appliances {
+		appliance.FollowOn = ""
+	}
+	if strings.TrimSpace(value) == "" {
+		return nil
+	}
+
+	for _, pair := range strings.Split(value, ",") {
+		id, followOn, found := strings.Cut(strings.TrimSpace(pair), ":")
+		if !found {
+			return fmt.Errorf("expected id:id, got %q", pair)
+		}
+		appliance := appliances.Find(id)
+		if appliance == nil {
+			return fmt.Errorf("unknown appliance %q", id)
+		}
+		appliance.FollowOn = followOn
+	}
+	return appliances.Validate()
 }
@@ -17,6 +17,7 @@ const (
 	ENOTFOUND       = "not_found"
 	ENOTIMPLEMENTED = "not_implemented"
 	EUNAUTHORIZED   = "unauthorized"
+	ETOOMANY        = "too_many_requests"
 )
 
 // Error represents an application-specific error. Application errors can be
@@ -6,15 +6,19 @@ import (
 	"time"
 )
 
-const WASHER_EVENT = "washer"
-const DRYER_EVENT = "dryer"
-
 type Event struct {
 	Id         int
 	Type       string
 	StartedAt  sql.NullTime
 	FinishedAt sql.NullTime
-	User       *User
+	// When the almost done notification was queued for the event, if it has been
+	AlmostDoneAt sql.NullTime
+	// When the event was flagged as having been open for longer than the appliance's longest cycle
+	StaleAt sql.NullTime
+	// Whether the event was finished by the watchdog, rather than by a finish message, so its finish
+	// time is only when it was noticed
+	TimedOut bool
+	User     *User
 }
 
 func (e *Event) Validate() error {
@@ -26,6 +30,10 @@ func (e *Event) Validate() error {
 		return Errorf(EINVALID, "Event start time required.")
 	}
 
+	if e.FinishedAt.Valid && e.FinishedAt.Time.Before(e.StartedAt.Time) {
+		return Errorf(EINVALID, "Event can't finish before it started.")
+	}
+
 	// if e.FinishedAt.IsZero() {
 	// 	return Errorf(EINVALID, "Event finish time required.")
 	// }
@@ -38,32 +46,111 @@ type EventUpdate struct {
 	FinishedAt sql.NullTime
 }
 
+// EventFilter matches events meeting all of the set criteria
 type EventFilter struct {
-	Id         *int
-	Type       *string
-	StartedAt  time.Time
-	FinishedAt time.Time
+	Id   *int
+	Type *string
+	// Only events started at or after this time
+	StartedAfter time.Time
+	// Only events started before this time
+	StartedBefore time.Time
+	// Only events finished at or after this time
+	FinishedAfter time.Time
+	// Only events finished before this time
+	FinishedBefore time.Time
+	// Only events that haven't finished
+	Unfinished bool
 	Limit      int
 	Offset     int
-	OrderBy    []string
+	// Columns to order by, each optionally followed by ASC or DESC. One of id, type, started_at or finished_at
+	OrderBy []string
+}
+
+// Minimum number of finished cycles needed before a finish time is estimated
+const MIN_EVENT_STATS_COUNT = 3
+
+// EventStats summarises how long past cycles of an appliance took
+type EventStats struct {
+	Type string
+	// Time of day the cycles were taken from, eg "morning". Empty if cycles from any time of day were used
+	TimeOfDay string
+	Count     int
+	Median    time.Duration
+	P90       time.Duration
+}
+
+// EstimatedFinish returns when a cycle that started at the given time is expected to finish, based on
+// the median duration. False is returned if there aren't enough past cycles to make an estimate.
+func (s *EventStats) EstimatedFinish(startedAt time.Time) (time.Time, bool) {
+	if s == nil || s.Count < MIN_EVENT_STATS_COUNT {
+		return time.Time{}, false
+	}
+	return startedAt.Add(s.Median), true
+}
+
+// TimeOfDay returns which part of the day the time falls in, in local time
+func TimeOfDay(t time.Time) string {
+	switch hour := t.Local().Hour(); {
+	case hour >= 5 && hour < 12:
+		return "morning"
+	case hour >= 12 && hour < 17:
+		return "afternoon"
+	case hour >= 17 && hour < 22:
+		return "evening"
+	default:
+		return "night"
+	}
 }
 
 type EventService interface {
 	FindEventById(ctx context.Context, userId int) (*Event, error)
 	FindMostRecentEvent(ctx context.Context, eventType string) (*Event, error)
-	CreateEvent(ctx context.Context, event *Event) error
-	UpdateEvent(ctx context.Context, id int, update EventUpdate) (*Event, error)
+	FindEvents(ctx context.Context, filter EventFilter) ([]*Event, int, error)
+	// StartEvent creates an event of the type, attaches the pending user events for it and materialises any
+	// matching standing subscriptions, in a single transaction. Returns ECONFLICT if an event of the type is
+	// already running or started at the same time, and EINVALID if it started before the last event of
+	// the type finished. Returns the event and the user events subscribed to it
+	StartEvent(ctx context.Context, eventType string, startedAt time.Time) (*Event, []*UserEvent, error)
+	// FinishRunningEvent sets the finish time of the running event of the type, queues a copy of the
+	// notification for each subscribed user and schedules their reminders after each of the remindAfter
+	// durations, in a single transaction. Returns ENOTFOUND if none is running, and EINVALID if it would
+	// finish before it started. The notifications are one per subscriber to notify
+	FinishRunningEvent(ctx context.Context, eventType string, finishedAt time.Time, notification Notification, remindAfter []time.Duration) (*Event, []*Notification, error)
+	// FindEventStats returns duration stats for recent finished cycles of the type. If startedAt is set,
+	// cycles started at the same time of day are preferred when there are enough of them
+	FindEventStats(ctx context.Context, eventType string, startedAt time.Time) (*EventStats, error)
+	// NotifyAlmostDone queues a copy of the notification for each subscribed user of an unfinished event.
+	// It can only be done once per event
+	NotifyAlmostDone(ctx context.Context, id int, notification Notification) ([]*Notification, error)
+	// FlagStaleEvents flags unfinished events of the type that started before the given time, and
+	// returns the ones that weren't already flagged
+	FlagStaleEvents(ctx context.Context, eventType string, startedBefore time.Time) ([]*Event, error)
+	// TimeOutEvent finishes the event like FinishRunningEvent, but by id and marked as timed out, for
+	// events whose finish was never received
+	TimeOutEvent(ctx context.Context, id int, finishedAt time.Time, notification Notification, remindAfter []time.Duration) (*Event, []*Notification, error)
 }
 
 type UserEventFilter struct {
-	Id *int
+	Id     *int
+	UserId *int
+	// Matches user events for a specific event. Zero matches user events waiting for the next event
+	EventId *int
+	Type    *string
+	// Only user events that are waiting for an event, or whose event hasn't finished
+	Active bool
+	Limit  int
+	Offset int
 }
 
 type UserEventService interface {
 	FindUserEventById(ctx context.Context, id int) (*UserEvent, error)
 	FindUserNamesByEventId(ctx context.Context, eventId int) ([]string, error)
-	FindByUserName(ctx context.Context, name string, eventType string) ([]*UserEvent, int, error)
-	FindUpcomingUserEvents(ctx context.Context, eventType string) ([]*UserEvent, int, error)
+	FindUserEvents(ctx context.Context, filter UserEventFilter) ([]*UserEvent, int, error)
 	CreateUserEvent(ctx context.Context, userEvent *UserEvent) error
+	// Subscribe registers the user for the running event of the type, or the next one if none is running,
+	// unless they already are. userEvent is set to the registration. Returns the running event, nil if
+	// they're waiting for the next one, and whether they were already registered
+	Subscribe(ctx context.Context, userEvent *UserEvent) (*Event, bool, error)
 	UpdateUserEvent(ctx context.Context, id int, update UserEventUpdate) (*UserEvent, error)
+	DeleteUserEvent(ctx context.Context, id int) error
 }
@@ -0,0 +1,24 @@
+package laundryNotify
+
+import "time"
+
+// MQTTStatus describes the connection to the mqtt broker
+type MQTTStatus struct {
+	Connected bool
+	// When the current connection was established. Zero if it isn't connected
+	ConnectedAt time.Time
+	// Number of times the connection has been re-established after being lost
+	Reconnects int
+	// When a message was last received on any subscribed topic. Zero if none have been
+	LastMessageAt time.Time
+	// Number of received messages waiting to be handled
+	Queued int
+	// Number of times a full queue held up the mqtt client
+	Blocked int
+	// Number of messages dropped because the queue was full
+	Dropped int
+}
+
+type MQTTStatusService interface {
+	MQTTStatus() MQTTStatus
+}
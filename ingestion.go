@@ -0,0 +1,87 @@
+package laundryNotify
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// What was done with an incoming start or finish message
+const INGEST_ACCEPTED = "accepted"
+
+// The same appliance, kind and timestamp was already accepted, eg a retained or redelivered message
+const INGEST_DUPLICATE = "duplicate"
+
+// The message didn't change anything, eg a start while a cycle is already running
+const INGEST_IGNORED = "ignored"
+
+// The message was inconsistent with the event it applied to, eg a finish earlier than the start
+const INGEST_REJECTED = "rejected"
+
+// A start that arrived after the cycle it belonged to had already finished
+const INGEST_LATE = "late"
+
+// Ingestion records the decision made about an incoming start or finish message, for debugging
+type Ingestion struct {
+	Id int
+	// Appliance the message was for
+	Type string
+	// Either started_at or finished_at
+	Kind string
+	// Timestamp in the message
+	At      time.Time
+	Topic   string
+	Payload string
+	// One of the INGEST_ constants
+	Decision string
+	// Why the decision was made, if it wasn't accepted
+	Reason string
+	// The event that was started or finished. Zero if there wasn't one
+	EventId   int
+	CreatedAt sql.NullTime
+}
+
+func (i *Ingestion) Validate() error {
+	if i.Type == "" {
+		return Errorf(EINVALID, "Ingestion type required.")
+	}
+
+	if i.Kind == "" {
+		return Errorf(EINVALID, "Ingestion kind required.")
+	}
+
+	switch i.Decision {
+	case INGEST_ACCEPTED, INGEST_DUPLICATE, INGEST_IGNORED, INGEST_REJECTED, INGEST_LATE:
+	default:
+		return Errorf(EINVALID, "Invalid ingestion decision: %s", i.Decision)
+	}
+
+	return nil
+}
+
+type IngestionFilter struct {
+	Type *string
+	Kind *string
+	// Only ingestions of messages with this timestamp
+	At       time.Time
+	Decision *string
+
+	Offset int
+	Limit  int
+}
+
+// IngestionService applies incoming start and finish messages. The duplicate check, the start or finish and
+// the ingestion log entry happen in a single transaction, so a message can't be accepted twice
+type IngestionService interface {
+	// FindIngestions returns matching ingestions, newest first
+	FindIngestions(ctx context.Context, filter IngestionFilter) ([]*Ingestion, int, error)
+	// IngestStart starts an event of the ingestion's type at its timestamp, like EventService.StartEvent,
+	// unless the message is a duplicate. The ingestion's decision, reason and event are set and it's
+	// recorded. Returns the event and its user events if it was accepted. Errors are only returned if the
+	// message couldn't be applied or recorded, in which case nothing is saved and it can be tried again
+	IngestStart(ctx context.Context, ingestion *Ingestion) (*Event, []*UserEvent, error)
+	// IngestFinish finishes the running event of the ingestion's type, like EventService.FinishRunningEvent,
+	// unless the message is a duplicate. The ingestion is recorded as for IngestStart. Returns the event and
+	// the notifications queued if it was accepted
+	IngestFinish(ctx context.Context, ingestion *Ingestion, notification Notification, remindAfter []time.Duration) (*Event, []*Notification, error)
+}
@@ -0,0 +1,648 @@
+package http
+
+import (
+	"database/sql"
+	laundryNotify "jallier/laundry-notify"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const defaultApiLimit = 20
+const maxApiLimit = 100
+
+func (s *HttpServer) registerApiRoutes() {
+	routerGroup := s.router.Group("/api/v1")
+	routerGroup.GET("/appliances", s.handleApiAppliances)
+	routerGroup.GET("/appliances/:id/stats", s.handleApiApplianceStats)
+	routerGroup.GET("/events", s.handleApiEvents)
+	routerGroup.GET("/events/:id", s.handleApiEvent)
+	routerGroup.POST("/subscriptions", s.handleApiCreateSubscription)
+	routerGroup.DELETE("/subscriptions/:id", s.handleApiDeleteSubscription)
+	routerGroup.POST("/login", s.handleApiLogin)
+	routerGroup.POST("/logout", s.handleApiLogout)
+	routerGroup.GET("/users", s.handleApiUsers)
+	routerGroup.GET("/users/:name", s.handleApiUser)
+	routerGroup.POST("/users/:name/events/:id/collected", s.handleApiCollected)
+	routerGroup.GET("/users/:name/preferences", s.handleApiPreferences)
+	routerGroup.PUT("/users/:name/preferences", s.handleApiSavePreferences)
+	routerGroup.GET("/users/:name/standing-subscriptions", s.handleApiStandingSubscriptions)
+	routerGroup.POST("/users/:name/standing-subscriptions", s.handleApiCreateStandingSubscription)
+	routerGroup.DELETE("/users/:name/standing-subscriptions/:id", s.handleApiDeleteStandingSubscription)
+}
+
+type applianceResponse struct {
+	Id                string         `json:"id"`
+	Name              string         `json:"name"`
+	Topic             string         `json:"topic"`
+	Running           bool           `json:"running"`
+	CurrentEvent      *eventResponse `json:"current_event"`
+	EstimatedFinishAt *time.Time     `json:"estimated_finish_at"`
+}
+
+type statsResponse struct {
+	Type          string `json:"type"`
+	TimeOfDay     string `json:"time_of_day,omitempty"`
+	Count         int    `json:"count"`
+	MedianSeconds int    `json:"median_seconds"`
+	P90Seconds    int    `json:"p90_seconds"`
+}
+
+type eventResponse struct {
+	Id         int        `json:"id"`
+	Type       string     `json:"type"`
+	StartedAt  *time.Time `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at"`
+	// Set if the event was open for longer than the appliance's longest cycle
+	StaleAt *time.Time `json:"stale_at,omitempty"`
+	// Set if the event was finished automatically, so the finish time is only when it was noticed
+	TimedOut bool `json:"timed_out"`
+}
+
+type userResponse struct {
+	Id            int                     `json:"id"`
+	Name          string                  `json:"name"`
+	CreatedAt     *time.Time              `json:"created_at"`
+	Subscriptions []*subscriptionResponse `json:"subscriptions,omitempty"`
+}
+
+type subscriptionResponse struct {
+	Id        int        `json:"id"`
+	UserId    int        `json:"user_id"`
+	Type      string     `json:"type"`
+	EventId   *int       `json:"event_id"`
+	CreatedAt *time.Time `json:"created_at"`
+	// Type of appliance the load will be followed on to
+	FollowOn string `json:"follow_on,omitempty"`
+	// The subscription this one was created from when the load moved on
+	ChainedFromId *int `json:"chained_from_id,omitempty"`
+}
+
+type preferencesResponse struct {
+	Channel    string     `json:"channel"`
+	Target     string     `json:"target"`
+	QuietStart string     `json:"quiet_start"`
+	QuietEnd   string     `json:"quiet_end"`
+	Priority   string     `json:"priority"`
+	Language   string     `json:"language"`
+	UpdatedAt  *time.Time `json:"updated_at"`
+}
+
+type standingSubscriptionResponse struct {
+	Id          int        `json:"id"`
+	Type        string     `json:"type"`
+	Schedule    string     `json:"schedule"`
+	Weekdays    []int      `json:"weekdays"`
+	WindowStart string     `json:"window_start,omitempty"`
+	WindowEnd   string     `json:"window_end,omitempty"`
+	Remaining   int        `json:"remaining,omitempty"`
+	ExpiresAt   *time.Time `json:"expires_at"`
+	CreatedAt   *time.Time `json:"created_at"`
+}
+
+type listResponse[T any] struct {
+	Items []T `json:"items"`
+	Total int `json:"total"`
+}
+
+func newEventResponse(event *laundryNotify.Event) *eventResponse {
+	if event == nil {
+		return nil
+	}
+	return &eventResponse{
+		Id:         event.Id,
+		Type:       event.Type,
+		StartedAt:  nullTimePtr(event.StartedAt),
+		FinishedAt: nullTimePtr(event.FinishedAt),
+		StaleAt:    nullTimePtr(event.StaleAt),
+		TimedOut:   event.TimedOut,
+	}
+}
+
+func newSubscriptionResponse(userEvent *laundryNotify.UserEvent) *subscriptionResponse {
+	resp := &subscriptionResponse{
+		Id:        userEvent.Id,
+		UserId:    userEvent.UserId,
+		Type:      userEvent.Type,
+		CreatedAt: nullTimePtr(userEvent.CreatedAt),
+		FollowOn:  userEvent.FollowOn,
+	}
+	if userEvent.ChainedFromId > 0 {
+		resp.ChainedFromId = &userEvent.ChainedFromId
+	}
+	if userEvent.EventId > 0 {
+		eventId := userEvent.EventId
+		resp.EventId = &eventId
+	}
+	return resp
+}
+
+func newPreferencesResponse(preferences *laundryNotify.Preferences) *preferencesResponse {
+	return &preferencesResponse{
+		Channel:    preferences.Channel,
+		Target:     preferences.Target,
+		QuietStart: preferences.QuietStart,
+		QuietEnd:   preferences.QuietEnd,
+		Priority:   preferences.Priority,
+		Language:   preferences.Language,
+		UpdatedAt:  nullTimePtr(preferences.UpdatedAt),
+	}
+}
+
+func newStandingSubscriptionResponse(subscription *laundryNotify.StandingSubscription) *standingSubscriptionResponse {
+	weekdays := make([]int, 0, len(subscription.Weekdays))
+	for _, day := range subscription.Weekdays {
+		weekdays = append(weekdays, int(day))
+	}
+	return &standingSubscriptionResponse{
+		Id:          subscription.Id,
+		Type:        subscription.Type,
+		Schedule:    subscription.Schedule,
+		Weekdays:    weekdays,
+		WindowStart: subscription.WindowStart,
+		WindowEnd:   subscription.WindowEnd,
+		Remaining:   subscription.Remaining,
+		ExpiresAt:   nullTimePtr(subscription.ExpiresAt),
+		CreatedAt:   nullTimePtr(subscription.CreatedAt),
+	}
+}
+
+func nullTimePtr(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	return &t.Time
+}
+
+func (s *HttpServer) handleApiAppliances(c *gin.Context) {
+	appliances := make([]*applianceResponse, 0, len(s.Appliances))
+	for _, appliance := range s.Appliances {
+		mostRecentEvent, err := s.EventService.FindMostRecentEvent(c.Request.Context(), appliance.Id)
+		if err != nil {
+			apiError(c, err)
+			return
+		}
+		appliances = append(appliances, &applianceResponse{
+			Id:                appliance.Id,
+			Name:              appliance.Name,
+			Topic:             appliance.Topic,
+			Running:           mostRecentEvent != nil && !mostRecentEvent.FinishedAt.Valid,
+			CurrentEvent:      newEventResponse(mostRecentEvent),
+			EstimatedFinishAt: s.estimatedFinish(mostRecentEvent),
+		})
+	}
+
+	c.JSON(http.StatusOK, listResponse[*applianceResponse]{Items: appliances, Total: len(appliances)})
+}
+
+func (s *HttpServer) handleApiApplianceStats(c *gin.Context) {
+	appliance := s.Appliances.Find(c.Param("id"))
+	if appliance == nil {
+		apiError(c, laundryNotify.Errorf(laundryNotify.ENOTFOUND, "Appliance not found: %s", c.Param("id")))
+		return
+	}
+
+	// Stats for a specific time of day can be requested with a timestamp
+	startedAt, err := queryTime(c, "started_at")
+	if err != nil {
+		apiError(c, err)
+		return
+	}
+
+	stats, err := s.EventService.FindEventStats(c.Request.Context(), appliance.Id, startedAt)
+	if err != nil {
+		apiError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, &statsResponse{
+		Type:          stats.Type,
+		TimeOfDay:     stats.TimeOfDay,
+		Count:         stats.Count,
+		MedianSeconds: int(stats.Median.Seconds()),
+		P90Seconds:    int(stats.P90.Seconds()),
+	})
+}
+
+func (s *HttpServer) handleApiEvents(c *gin.Context) {
+	filter := laundryNotify.EventFilter{
+		Limit:   defaultApiLimit,
+		OrderBy: []string{"started_at DESC"},
+	}
+	if v := c.Query("type"); v != "" {
+		if s.Appliances.Find(v) == nil {
+			apiError(c, laundryNotify.Errorf(laundryNotify.EINVALID, "Unknown appliance type: %s", v))
+			return
+		}
+		filter.Type = &v
+	}
+	if v := c.Query("order"); v != "" {
+		filter.OrderBy = strings.Split(v, ",")
+	}
+	if v := c.Query("unfinished"); v != "" {
+		unfinished, err := strconv.ParseBool(v)
+		if err != nil {
+			apiError(c, laundryNotify.Errorf(laundryNotify.EINVALID, "unfinished must be true or false"))
+			return
+		}
+		filter.Unfinished = unfinished
+	}
+
+	var err error
+	for key, t := range map[string]*time.Time{
+		"started_after":   &filter.StartedAfter,
+		"started_before":  &filter.StartedBefore,
+		"finished_after":  &filter.FinishedAfter,
+		"finished_before": &filter.FinishedBefore,
+	} {
+		if *t, err = queryTime(c, key); err != nil {
+			apiError(c, err)
+			return
+		}
+	}
+	if filter.Limit, err = queryInt(c, "limit", defaultApiLimit); err != nil {
+		apiError(c, err)
+		return
+	} else if filter.Limit <= 0 || filter.Limit > maxApiLimit {
+		apiError(c, laundryNotify.Errorf(laundryNotify.EINVALID, "limit must be between 1 and %d", maxApiLimit))
+		return
+	}
+	if filter.Offset, err = queryInt(c, "offset", 0); err != nil {
+		apiError(c, err)
+		return
+	} else if filter.Offset < 0 {
+		apiError(c, laundryNotify.Errorf(laundryNotify.EINVALID, "offset must not be negative"))
+		return
+	}
+
+	events, n, err := s.EventService.FindEvents(c.Request.Context(), filter)
+	if err != nil {
+		apiError(c, err)
+		return
+	}
+
+	items := make([]*eventResponse, 0, len(events))
+	for _, event := range events {
+		items = append(items, newEventResponse(event))
+	}
+	c.JSON(http.StatusOK, listResponse[*eventResponse]{Items: items, Total: n})
+}
+
+func (s *HttpServer) handleApiEvent(c *gin.Context) {
+	id, err := paramInt(c, "id")
+	if err != nil {
+		apiError(c, err)
+		return
+	}
+
+	event, err := s.EventService.FindEventById(c.Request.Context(), id)
+	if err != nil {
+		apiError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, newEventResponse(event))
+}
+
+func (s *HttpServer) handleApiCreateSubscription(c *gin.Context) {
+	var req RegisterRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apiError(c, laundryNotify.Errorf(laundryNotify.EINVALID, "Invalid json body."))
+		return
+	}
+
+	sub, err := s.subscribe(c.Request.Context(), req, s.sessionUserId(c))
+	if err != nil {
+		apiError(c, err)
+		return
+	}
+
+	status := http.StatusCreated
+	if sub.PreviouslyRegistered {
+		status = http.StatusOK
+	}
+	c.JSON(status, gin.H{
+		"subscription":          newSubscriptionResponse(sub.UserEvent),
+		"event":                 newEventResponse(sub.Event),
+		"previously_registered": sub.PreviouslyRegistered,
+	})
+}
+
+func (s *HttpServer) handleApiDeleteSubscription(c *gin.Context) {
+	id, err := paramInt(c, "id")
+	if err != nil {
+		apiError(c, err)
+		return
+	}
+
+	userEvent, err := s.UserEventService.FindUserEventById(c.Request.Context(), id)
+	if err != nil {
+		apiError(c, err)
+		return
+	}
+	user, err := s.UserService.FindUserById(c.Request.Context(), userEvent.UserId)
+	if err != nil {
+		apiError(c, err)
+		return
+	} else if user == nil {
+		apiError(c, laundryNotify.Errorf(laundryNotify.ENOTFOUND, "User not found: %d", userEvent.UserId))
+		return
+	}
+	if err := s.authorize(c, user); err != nil {
+		apiError(c, err)
+		return
+	}
+
+	if err := s.UserEventService.DeleteUserEvent(c.Request.Context(), id); err != nil {
+		apiError(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func (s *HttpServer) handleApiUsers(c *gin.Context) {
+	users, n, err := s.UserService.FindMostRecentUsers(c.Request.Context(), c.Query("name"))
+	if err != nil {
+		apiError(c, err)
+		return
+	}
+
+	items := make([]*userResponse, 0, len(users))
+	for _, user := range users {
+		items = append(items, &userResponse{Id: user.Id, Name: user.Name, CreatedAt: nullTimePtr(user.CreatedAt)})
+	}
+	c.JSON(http.StatusOK, listResponse[*userResponse]{Items: items, Total: n})
+}
+
+func (s *HttpServer) handleApiUser(c *gin.Context) {
+	user, err := s.UserService.FindUserByName(c.Request.Context(), c.Param("name"))
+	if err != nil {
+		apiError(c, err)
+		return
+	} else if user == nil {
+		apiError(c, laundryNotify.Errorf(laundryNotify.ENOTFOUND, "User not found: %s", c.Param("name")))
+		return
+	}
+
+	if err := s.authorize(c, user); err != nil {
+		apiError(c, err)
+		return
+	}
+
+	userEvents, _, err := s.UserEventService.FindUserEvents(c.Request.Context(), laundryNotify.UserEventFilter{
+		UserId: &user.Id,
+		Active: true,
+	})
+	if err != nil {
+		apiError(c, err)
+		return
+	}
+
+	resp := &userResponse{
+		Id:            user.Id,
+		Name:          user.Name,
+		CreatedAt:     nullTimePtr(user.CreatedAt),
+		Subscriptions: make([]*subscriptionResponse, 0, len(userEvents)),
+	}
+	for _, userEvent := range userEvents {
+		resp.Subscriptions = append(resp.Subscriptions, newSubscriptionResponse(userEvent))
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+func (s *HttpServer) handleApiCollected(c *gin.Context) {
+	id, err := paramInt(c, "id")
+	if err != nil {
+		apiError(c, err)
+		return
+	}
+
+	user, err := s.UserService.FindUserByName(c.Request.Context(), c.Param("name"))
+	if err != nil {
+		apiError(c, err)
+		return
+	} else if user == nil {
+		apiError(c, laundryNotify.Errorf(laundryNotify.ENOTFOUND, "User not found: %s", c.Param("name")))
+		return
+	}
+
+	if err := s.authorize(c, user); err != nil {
+		apiError(c, err)
+		return
+	}
+
+	n, err := s.ReminderService.AcknowledgeReminders(c.Request.Context(), user.Id, id)
+	if err != nil {
+		apiError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"reminders_cancelled": n})
+}
+
+func (s *HttpServer) handleApiPreferences(c *gin.Context) {
+	user, err := s.UserService.FindUserByName(c.Request.Context(), c.Param("name"))
+	if err != nil {
+		apiError(c, err)
+		return
+	} else if user == nil {
+		apiError(c, laundryNotify.Errorf(laundryNotify.ENOTFOUND, "User not found: %s", c.Param("name")))
+		return
+	}
+
+	if err := s.authorize(c, user); err != nil {
+		apiError(c, err)
+		return
+	}
+
+	preferences, err := s.PreferencesService.FindPreferences(c.Request.Context(), user.Id)
+	if err != nil {
+		apiError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, newPreferencesResponse(preferences))
+}
+
+func (s *HttpServer) handleApiSavePreferences(c *gin.Context) {
+	user, err := s.UserService.FindUserByName(c.Request.Context(), c.Param("name"))
+	if err != nil {
+		apiError(c, err)
+		return
+	} else if user == nil {
+		apiError(c, laundryNotify.Errorf(laundryNotify.ENOTFOUND, "User not found: %s", c.Param("name")))
+		return
+	}
+
+	if err := s.authorize(c, user); err != nil {
+		apiError(c, err)
+		return
+	}
+
+	var req PreferencesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apiError(c, laundryNotify.Errorf(laundryNotify.EINVALID, "Invalid json body."))
+		return
+	}
+
+	preferences, err := s.savePreferences(c.Request.Context(), user, req)
+	if err != nil {
+		apiError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, newPreferencesResponse(preferences))
+}
+
+func (s *HttpServer) handleApiStandingSubscriptions(c *gin.Context) {
+	user, err := s.UserService.FindUserByName(c.Request.Context(), c.Param("name"))
+	if err != nil {
+		apiError(c, err)
+		return
+	} else if user == nil {
+		apiError(c, laundryNotify.Errorf(laundryNotify.ENOTFOUND, "User not found: %s", c.Param("name")))
+		return
+	}
+
+	if err := s.authorize(c, user); err != nil {
+		apiError(c, err)
+		return
+	}
+
+	subscriptions, n, err := s.StandingSubscriptionService.FindStandingSubscriptions(c.Request.Context(), laundryNotify.StandingSubscriptionFilter{
+		UserId: &user.Id,
+		Active: true,
+	})
+	if err != nil {
+		apiError(c, err)
+		return
+	}
+
+	items := make([]*standingSubscriptionResponse, 0, len(subscriptions))
+	for _, subscription := range subscriptions {
+		items = append(items, newStandingSubscriptionResponse(subscription))
+	}
+	c.JSON(http.StatusOK, listResponse[*standingSubscriptionResponse]{Items: items, Total: n})
+}
+
+func (s *HttpServer) handleApiCreateStandingSubscription(c *gin.Context) {
+	user, err := s.UserService.FindUserByName(c.Request.Context(), c.Param("name"))
+	if err != nil {
+		apiError(c, err)
+		return
+	} else if user == nil {
+		apiError(c, laundryNotify.Errorf(laundryNotify.ENOTFOUND, "User not found: %s", c.Param("name")))
+		return
+	}
+
+	if err := s.authorize(c, user); err != nil {
+		apiError(c, err)
+		return
+	}
+
+	var req StandingSubscriptionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apiError(c, laundryNotify.Errorf(laundryNotify.EINVALID, "Invalid json body."))
+		return
+	}
+
+	subscription, err := s.createStandingSubscription(c.Request.Context(), user, req)
+	if err != nil {
+		apiError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, newStandingSubscriptionResponse(subscription))
+}
+
+func (s *HttpServer) handleApiDeleteStandingSubscription(c *gin.Context) {
+	id, err := paramInt(c, "id")
+	if err != nil {
+		apiError(c, err)
+		return
+	}
+
+	user, err := s.UserService.FindUserByName(c.Request.Context(), c.Param("name"))
+	if err != nil {
+		apiError(c, err)
+		return
+	} else if user == nil {
+		apiError(c, laundryNotify.Errorf(laundryNotify.ENOTFOUND, "User not found: %s", c.Param("name")))
+		return
+	}
+
+	if err := s.authorize(c, user); err != nil {
+		apiError(c, err)
+		return
+	}
+
+	if _, err := s.findUserStandingSubscription(c.Request.Context(), user, id); err != nil {
+		apiError(c, err)
+		return
+	}
+	if err := s.StandingSubscriptionService.DeleteStandingSubscription(c.Request.Context(), id); err != nil {
+		apiError(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func (s *HttpServer) handleApiLogin(c *gin.Context) {
+	var req LoginRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apiError(c, laundryNotify.Errorf(laundryNotify.EINVALID, "Invalid json body."))
+		return
+	}
+
+	if _, err := s.login(c, req); err != nil {
+		apiError(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func (s *HttpServer) handleApiLogout(c *gin.Context) {
+	s.clearSession(c)
+	c.Status(http.StatusNoContent)
+}
+
+// queryInt parses an optional integer query parameter
+func queryInt(c *gin.Context, key string, defaultValue int) (int, error) {
+	v := c.Query(key)
+	if v == "" {
+		return defaultValue, nil
+	}
+	i, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, laundryNotify.Errorf(laundryNotify.EINVALID, "%s must be an integer", key)
+	}
+	return i, nil
+}
+
+// queryTime parses an optional RFC3339 timestamp query parameter. Zero is returned if it isn't set
+func queryTime(c *gin.Context, key string) (time.Time, error) {
+	v := c.Query(key)
+	if v == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return time.Time{}, laundryNotify.Errorf(laundryNotify.EINVALID, "%s must be an RFC3339 timestamp", key)
+	}
+	return t, nil
+}
+
+// paramInt parses an integer path parameter
+func paramInt(c *gin.Context, key string) (int, error) {
+	i, err := strconv.Atoi(c.Param(key))
+	if err != nil {
+		return 0, laundryNotify.Errorf(laundryNotify.EINVALID, "Invalid %s.", key)
+	}
+	return i, nil
+}
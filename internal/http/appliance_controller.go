@@ -0,0 +1,91 @@
+package http
+
+import (
+	"io"
+	laundryNotify "jallier/laundry-notify"
+	"net/http"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/gin-gonic/gin"
+)
+
+// How often a comment is sent on idle streams, so proxies don't close the connection
+const streamKeepAlive = 30 * time.Second
+
+func (s *HttpServer) registerApplianceRoutes() {
+	s.router.GET("/appliances/:id/status", s.handleApplianceStatus)
+	s.router.GET("/appliances/stream", s.handleApplianceStream)
+}
+
+// handleApplianceStatus renders the status of a single appliance, so it can be swapped into the index page
+func (s *HttpServer) handleApplianceStatus(c *gin.Context) {
+	appliance := s.Appliances.Find(c.Param("id"))
+	if appliance == nil {
+		c.Status(http.StatusNotFound)
+		return
+	}
+
+	mostRecentEvent, err := s.EventService.FindMostRecentEvent(s.ctx, appliance.Id)
+	if err != nil {
+		log.Error("Error finding most recent event", "type", appliance.Id, "error", err)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	c.HTML(http.StatusOK, "partials/appliance-status.html", gin.H{
+		"appliance": appliance,
+		"event":     mostRecentEvent,
+		"estimate":  s.estimatedFinish(mostRecentEvent),
+	})
+}
+
+// estimatedFinish returns when a running event is expected to finish, or nil if it isn't running or
+// there aren't enough past cycles to tell
+func (s *HttpServer) estimatedFinish(event *laundryNotify.Event) *time.Time {
+	if event == nil || event.FinishedAt.Valid {
+		return nil
+	}
+
+	stats, err := s.EventService.FindEventStats(s.ctx, event.Type, event.StartedAt.Time)
+	if err != nil {
+		log.Error("Error finding event stats", "type", event.Type, "error", err)
+		return nil
+	}
+	estimate, ok := stats.EstimatedFinish(event.StartedAt.Time)
+	if !ok {
+		return nil
+	}
+	return &estimate
+}
+
+// handleApplianceStream is a server sent event stream of appliance updates. Each message is named
+// `appliance` with the id of the appliance that changed as the data.
+func (s *HttpServer) handleApplianceStream(c *gin.Context) {
+	sub := s.EventBus.Subscribe()
+	defer sub.Close()
+
+	keepAlive := time.NewTicker(streamKeepAlive)
+	defer keepAlive.Stop()
+
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case update, ok := <-sub.C():
+			if !ok {
+				return false
+			}
+			c.SSEvent("appliance", update.Event.Type)
+			return true
+		case <-keepAlive.C:
+			_, err := io.WriteString(w, ": keep-alive\n\n")
+			return err == nil
+		case <-c.Request.Context().Done():
+			return false
+		case <-s.ctx.Done():
+			return false
+		}
+	})
+}
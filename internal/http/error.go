@@ -0,0 +1,43 @@
+package http
+
+import (
+	laundryNotify "jallier/laundry-notify"
+	"net/http"
+
+	"github.com/charmbracelet/log"
+	"github.com/gin-gonic/gin"
+)
+
+// codes maps application error codes to http status codes
+var codes = map[string]int{
+	laundryNotify.ECONFLICT:       http.StatusConflict,
+	laundryNotify.EINVALID:        http.StatusBadRequest,
+	laundryNotify.ENOTFOUND:       http.StatusNotFound,
+	laundryNotify.ENOTIMPLEMENTED: http.StatusNotImplemented,
+	laundryNotify.EUNAUTHORIZED:   http.StatusUnauthorized,
+	laundryNotify.ETOOMANY:        http.StatusTooManyRequests,
+	laundryNotify.EINTERNAL:       http.StatusInternalServerError,
+}
+
+// ErrorStatusCode returns the http status code for an application error code
+func ErrorStatusCode(code string) int {
+	if v, ok := codes[code]; ok {
+		return v
+	}
+	return http.StatusInternalServerError
+}
+
+// apiError writes an application error as json. Internal errors are logged, and only a generic message
+// is returned to the client.
+func apiError(c *gin.Context, err error) {
+	code, message := laundryNotify.ErrorCode(err), laundryNotify.ErrorMessage(err)
+
+	if code == laundryNotify.EINTERNAL {
+		log.Error("http error", "method", c.Request.Method, "path", c.Request.URL.Path, "error", err)
+	}
+
+	c.AbortWithStatusJSON(ErrorStatusCode(code), gin.H{
+		"code":  code,
+		"error": message,
+	})
+}
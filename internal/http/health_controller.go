@@ -0,0 +1,75 @@
+package http
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func (s *HttpServer) registerHealthRoutes() {
+	s.router.GET("/healthz", s.handleHealthz)
+	s.router.GET("/readyz", s.handleReadyz)
+}
+
+type healthResponse struct {
+	// Either ok or degraded
+	Status string              `json:"status"`
+	MQTT   *mqttStatusResponse `json:"mqtt,omitempty"`
+}
+
+type mqttStatusResponse struct {
+	Connected     bool       `json:"connected"`
+	ConnectedAt   *time.Time `json:"connected_at"`
+	Reconnects    int        `json:"reconnects"`
+	LastMessageAt *time.Time `json:"last_message_at"`
+	Queued        int        `json:"queued"`
+	Blocked       int        `json:"blocked"`
+	Dropped       int        `json:"dropped"`
+}
+
+// handleHealthz reports whether the process is up, along with the state of its connections. It only
+// fails if the server can't respond at all, so a lost broker connection doesn't get the service restarted
+func (s *HttpServer) handleHealthz(c *gin.Context) {
+	c.JSON(http.StatusOK, s.health())
+}
+
+// handleReadyz is like handleHealthz, but responds with 503 unless everything is connected
+func (s *HttpServer) handleReadyz(c *gin.Context) {
+	health := s.health()
+	if health.Status != "ok" {
+		c.JSON(http.StatusServiceUnavailable, health)
+		return
+	}
+	c.JSON(http.StatusOK, health)
+}
+
+func (s *HttpServer) health() *healthResponse {
+	health := &healthResponse{Status: "ok"}
+	if s.MQTTStatusService == nil {
+		return health
+	}
+
+	status := s.MQTTStatusService.MQTTStatus()
+	health.MQTT = &mqttStatusResponse{
+		Connected:     status.Connected,
+		ConnectedAt:   timePtr(status.ConnectedAt),
+		Reconnects:    status.Reconnects,
+		LastMessageAt: timePtr(status.LastMessageAt),
+		Queued:        status.Queued,
+		Blocked:       status.Blocked,
+		Dropped:       status.Dropped,
+	}
+	if !status.Connected {
+		health.Status = "degraded"
+	}
+	return health
+}
+
+// timePtr returns nil for the zero time, so it's encoded as null
+func timePtr(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
@@ -0,0 +1,135 @@
+package http
+
+import (
+	laundryNotify "jallier/laundry-notify"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/gin-gonic/gin"
+)
+
+const historyPageSize = 20
+
+// Format of the from and to dates on the history page
+const historyDateFormat = "2006-01-02"
+
+func (s *HttpServer) registerHistoryRoutes() {
+	s.router.GET("/history", s.handleHistory)
+	// Old link from the index page
+	s.router.GET("/details.html", func(c *gin.Context) {
+		c.Redirect(http.StatusMovedPermanently, "/history")
+	})
+}
+
+// historyEvent is a past event along with its appliance and who was subscribed, for display
+type historyEvent struct {
+	Event     *laundryNotify.Event
+	Appliance *laundryNotify.Appliance
+	// How long the cycle ran for. Zero if it hasn't finished
+	Duration time.Duration
+	Users    []string
+}
+
+func (s *HttpServer) handleHistory(c *gin.Context) {
+	filter := laundryNotify.EventFilter{
+		Limit:   historyPageSize,
+		OrderBy: []string{"started_at DESC"},
+	}
+
+	eventType, from, to := c.Query("type"), c.Query("from"), c.Query("to")
+	if eventType != "" {
+		if s.Appliances.Find(eventType) == nil {
+			s.renderHistoryError(c, http.StatusBadRequest, "Unknown appliance type: "+eventType)
+			return
+		}
+		filter.Type = &eventType
+	}
+	if from != "" {
+		t, err := time.ParseInLocation(historyDateFormat, from, time.Local)
+		if err != nil {
+			s.renderHistoryError(c, http.StatusBadRequest, "Invalid from date")
+			return
+		}
+		filter.StartedAfter = t
+	}
+	if to != "" {
+		t, err := time.ParseInLocation(historyDateFormat, to, time.Local)
+		if err != nil {
+			s.renderHistoryError(c, http.StatusBadRequest, "Invalid to date")
+			return
+		}
+		// Include the whole of the to date
+		filter.StartedBefore = t.AddDate(0, 0, 1)
+	}
+
+	page, err := queryInt(c, "page", 1)
+	if err != nil || page < 1 {
+		s.renderHistoryError(c, http.StatusBadRequest, "Invalid page")
+		return
+	}
+	filter.Offset = (page - 1) * historyPageSize
+
+	events, n, err := s.EventService.FindEvents(s.ctx, filter)
+	if err != nil {
+		log.Error("Error finding events", "error", err)
+		s.renderHistoryError(c, http.StatusInternalServerError, "Error finding past loads")
+		return
+	}
+
+	history := make([]historyEvent, 0, len(events))
+	for _, event := range events {
+		item := historyEvent{Event: event, Appliance: s.Appliances.Find(event.Type)}
+		if event.FinishedAt.Valid {
+			item.Duration = event.FinishedAt.Time.Sub(event.StartedAt.Time)
+		}
+		if item.Users, err = s.UserEventService.FindUserNamesByEventId(s.ctx, event.Id); err != nil {
+			log.Error("Error finding users for event", "id", event.Id, "error", err)
+		}
+		history = append(history, item)
+	}
+
+	// Links to the neighbouring pages keep the current filters
+	pageUrl := func(page int) string {
+		query := url.Values{}
+		for key, value := range map[string]string{"type": eventType, "from": from, "to": to} {
+			if value != "" {
+				query.Set(key, value)
+			}
+		}
+		query.Set("page", strconv.Itoa(page))
+		return "/history?" + query.Encode()
+	}
+	var prevUrl, nextUrl string
+	if page > 1 {
+		prevUrl = pageUrl(page - 1)
+	}
+	if filter.Offset+len(events) < n {
+		nextUrl = pageUrl(page + 1)
+	}
+
+	c.HTML(http.StatusOK, "history", gin.H{
+		"title":      "Laundry Notify",
+		"appliances": s.Appliances,
+		"events":     history,
+		"total":      n,
+		"type":       eventType,
+		"from":       from,
+		"to":         to,
+		"prevUrl":    prevUrl,
+		"nextUrl":    nextUrl,
+	})
+}
+
+func (s *HttpServer) renderHistoryError(c *gin.Context, status int, message string) {
+	c.HTML(status, "history", gin.H{
+		"title":      "Laundry Notify",
+		"appliances": s.Appliances,
+		"type":       c.Query("type"),
+		"from":       c.Query("from"),
+		"to":         c.Query("to"),
+		"error":      message,
+	})
+}
@@ -9,6 +9,7 @@ import (
 	"net/http"
 	"path/filepath"
 	"text/template"
+	"time"
 
 	"github.com/charmbracelet/log"
 	"github.com/foolin/goview"
@@ -21,12 +22,31 @@ type HttpServer struct {
 	Config struct {
 		Env           string
 		NtfyBaseTopic string
+		// Enabled notifier backends, which users can choose between
+		Notifiers []string
+		// Hosts users can set their webhook url to
+		WebhookHosts []string
+		// Key used to sign session cookies. A random one is used if it isn't set
+		SessionSecret []byte
+		// Public url of the site, used in sign in links. Links aren't sent if it isn't set
+		BaseURL string
 	}
-	UserService      laundryNotify.UserService
-	EventService     laundryNotify.EventService
-	UserEventService laundryNotify.UserEventService
-	ctx              context.Context
-	cancel           func()
+	Appliances         laundryNotify.Appliances
+	UserService        laundryNotify.UserService
+	EventService       laundryNotify.EventService
+	UserEventService   laundryNotify.UserEventService
+	ReminderService    laundryNotify.ReminderService
+	PreferencesService laundryNotify.PreferencesService
+	AuthService        laundryNotify.AuthService
+	// Subscriptions to every matching cycle of an appliance
+	StandingSubscriptionService laundryNotify.StandingSubscriptionService
+	// Used to send sign in links
+	NotificationService laundryNotify.NotificationService
+	EventBus            laundryNotify.EventBus
+	MQTTStatusService   laundryNotify.MQTTStatusService
+	loginLimiter        *loginLimiter
+	ctx                 context.Context
+	cancel              func()
 }
 
 //go:embed static/*
@@ -36,7 +56,8 @@ var staticFS, _ = fs.Sub(viewFS, "static")
 
 func NewHttpServer() *HttpServer {
 	server := &HttpServer{
-		router: gin.Default(),
+		router:       gin.Default(),
+		loginLimiter: newLoginLimiter(),
 	}
 	server.ctx, server.cancel = context.WithCancel(context.Background())
 
@@ -44,8 +65,10 @@ func NewHttpServer() *HttpServer {
 		Root:      "views",
 		Extension: ".html",
 		Master:    "layouts/master",
+		Partials:  []string{"partials/search", "partials/appliance-status"},
 		Funcs: template.FuncMap{
-			"dict": dict,
+			"dict":     dict,
+			"duration": formatDuration,
 		},
 	})
 	gvRenderer.SetFileHandler(embeddedFileHandler)
@@ -56,13 +79,21 @@ func NewHttpServer() *HttpServer {
 
 	// Register controllers with router
 	server.registerIndexRoute()
+	server.registerApplianceRoutes()
 	server.registerSearchRoute()
 	server.registerRegisterRoutes()
+	server.registerUserRoutes()
+	server.registerHistoryRoutes()
+	server.registerLoginRoutes()
+	server.registerApiRoutes()
+	server.registerHealthRoutes()
 
 	return server
 }
 
 func (s *HttpServer) Open() {
+	s.ensureSessionSecret()
+
 	if s.Config.Env == "dev" || s.Config.Env == "development" {
 		log.Debug("running http server in development mode")
 		s.router.ForwardedByClientIP = true
@@ -99,6 +130,15 @@ func dict(values ...interface{}) (map[string]interface{}, error) {
 	return dict, nil
 }
 
+// formatDuration formats a duration to the nearest minute, eg 1h 5m
+func formatDuration(d time.Duration) string {
+	d = d.Round(time.Minute)
+	if d < time.Hour {
+		return fmt.Sprintf("%dm", int(d.Minutes()))
+	}
+	return fmt.Sprintf("%dh %dm", int(d.Hours()), int(d.Minutes())%60)
+}
+
 func embeddedFileHandler(config goview.Config, tmpl string) (string, error) {
 	path := filepath.Join(config.Root, tmpl)
 	bytes, err := viewFS.ReadFile(path + config.Extension)
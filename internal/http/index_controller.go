@@ -3,6 +3,7 @@ package http
 import (
 	laundryNotify "jallier/laundry-notify"
 	"net/http"
+	"time"
 
 	"github.com/charmbracelet/log"
 	"github.com/gin-gonic/gin"
@@ -12,24 +13,38 @@ func (s *HttpServer) registerIndexRoute() {
 	s.router.GET("/", s.handleIndex)
 }
 
+// applianceStatus is an appliance along with its most recent event, for display
+type applianceStatus struct {
+	Appliance       *laundryNotify.Appliance
+	MostRecentEvent *laundryNotify.Event
+	EstimatedFinish *time.Time
+	// The appliance loads move on to, if users can follow their load on to one
+	FollowOn *laundryNotify.Appliance
+}
+
 func (s *HttpServer) handleIndex(c *gin.Context) {
 	users, _, err := s.UserService.FindMostRecentUsers(s.ctx, "")
 	if err != nil {
 		log.Error("Error finding most recent user", "error", err)
 	}
-	mostRecentWasherEvent, err := s.EventService.FindMostRecentEvent(s.ctx, laundryNotify.WASHER_EVENT)
-	if err != nil {
-		log.Error("Error finding most recent event", "error", err)
-	}
-	mostRecentDryerEvent, err := s.EventService.FindMostRecentEvent(s.ctx, laundryNotify.DRYER_EVENT)
-	if err != nil {
-		log.Error("Error finding most recent event", "error", err)
+
+	appliances := make([]applianceStatus, 0, len(s.Appliances))
+	for _, appliance := range s.Appliances {
+		mostRecentEvent, err := s.EventService.FindMostRecentEvent(s.ctx, appliance.Id)
+		if err != nil {
+			log.Error("Error finding most recent event", "type", appliance.Id, "error", err)
+		}
+		appliances = append(appliances, applianceStatus{
+			Appliance:       appliance,
+			MostRecentEvent: mostRecentEvent,
+			EstimatedFinish: s.estimatedFinish(mostRecentEvent),
+			FollowOn:        s.Appliances.Find(appliance.FollowOn),
+		})
 	}
 
 	c.HTML(http.StatusOK, "index", gin.H{
-		"title":                 "Laundry Notify",
-		"mostRecentWasherEvent": mostRecentWasherEvent,
-		"mostRecentDryerEvent":  mostRecentDryerEvent,
-		"users":                 users,
+		"title":      "Laundry Notify",
+		"appliances": appliances,
+		"users":      users,
 	})
 }
@@ -0,0 +1,226 @@
+package http
+
+import (
+	laundryNotify "jallier/laundry-notify"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/charmbracelet/log"
+	"github.com/gin-gonic/gin"
+)
+
+func (s *HttpServer) registerLoginRoutes() {
+	s.router.GET("/login", s.handleLogin)
+	s.router.POST("/login", s.handleLoginPin)
+	s.router.POST("/login/link", s.handleLoginLink)
+	s.router.GET("/login/:token", s.handleLoginToken)
+	s.router.POST("/logout", s.handleLogout)
+	s.router.POST("/users/:name/pin", s.handleSetPin)
+}
+
+type LoginRequest struct {
+	Name string `form:"name" json:"name"`
+	Pin  string `form:"pin" json:"pin"`
+	// Where to go once signed in
+	Next string `form:"next" json:"-"`
+}
+
+func (s *HttpServer) handleLogin(c *gin.Context) {
+	c.HTML(http.StatusOK, "login", gin.H{
+		"title": "Laundry Notify",
+		"name":  c.Query("name"),
+		"next":  c.Query("next"),
+	})
+}
+
+// login checks the user's PIN, signing them in if it matches. Attempts are refused once there have been
+// too many failures for the user or from the client's ip address
+func (s *HttpServer) login(c *gin.Context, req LoginRequest) (*laundryNotify.User, error) {
+	ipKey := ipLoginKey(c.ClientIP())
+	if !s.loginLimiter.allowed(ipKey, maxIpLoginFailures) {
+		log.Warn("Too many failed sign ins from ip", "ip", c.ClientIP())
+		return nil, laundryNotify.Errorf(laundryNotify.ETOOMANY, "Too many incorrect attempts, try again later.")
+	}
+
+	user, err := s.UserService.FindUserByName(c.Request.Context(), req.Name)
+	if err != nil {
+		return nil, err
+	} else if user == nil {
+		s.loginLimiter.fail(ipKey)
+		return nil, laundryNotify.Errorf(laundryNotify.EUNAUTHORIZED, "Incorrect name or PIN.")
+	}
+
+	userKey := userLoginKey(user.Id)
+	if !s.loginLimiter.allowed(userKey, maxUserLoginFailures) {
+		log.Warn("Too many failed sign ins for user", "user", user.Name)
+		return nil, laundryNotify.Errorf(laundryNotify.ETOOMANY, "Too many incorrect attempts, try again later.")
+	}
+
+	if err := s.AuthService.AuthenticatePin(c.Request.Context(), user.Id, req.Pin); err != nil {
+		if laundryNotify.ErrorCode(err) == laundryNotify.EUNAUTHORIZED {
+			s.loginLimiter.fail(ipKey, userKey)
+		}
+		return nil, err
+	}
+	s.loginLimiter.reset(userKey)
+
+	if err := s.setSession(c, user.Id); err != nil {
+		return nil, err
+	}
+	log.Info("User signed in", "user", user.Name)
+	return user, nil
+}
+
+func (s *HttpServer) handleLoginPin(c *gin.Context) {
+	var req LoginRequest
+	c.Bind(&req)
+
+	user, err := s.login(c, req)
+	if err != nil {
+		c.HTML(ErrorStatusCode(laundryNotify.ErrorCode(err)), "login", gin.H{
+			"title": "Laundry Notify",
+			"name":  req.Name,
+			"next":  req.Next,
+			"error": laundryNotify.ErrorMessage(err),
+		})
+		return
+	}
+
+	c.Redirect(http.StatusSeeOther, safeRedirect(req.Next, "/users/"+url.PathEscape(user.Name)))
+}
+
+// handleLoginLink sends a sign in link over the user's notification channel. Links are only sent to
+// users that have set a target in their preferences, as derived topics are easy to guess. They're only
+// sent when BASE_URL is set, as the request's Host header can be forged to point the link elsewhere.
+func (s *HttpServer) handleLoginLink(c *gin.Context) {
+	var req LoginRequest
+	c.Bind(&req)
+
+	if s.Config.BaseURL == "" {
+		log.Warn("Not sending sign in link, BASE_URL isn't set", "user", req.Name)
+		c.HTML(http.StatusNotImplemented, "login", gin.H{
+			"title": "Laundry Notify",
+			"name":  req.Name,
+			"error": "Sign in links aren't available, as the site's url hasn't been configured.",
+		})
+		return
+	}
+
+	if err := s.sendLoginLink(req.Name); err != nil {
+		log.Error("Error sending sign in link", "user", req.Name, "error", err)
+	}
+
+	// Always show the same message, so it can't be used to find out about users
+	c.HTML(http.StatusOK, "login", gin.H{
+		"title":    "Laundry Notify",
+		"name":     req.Name,
+		"linkSent": true,
+	})
+}
+
+func (s *HttpServer) sendLoginLink(name string) error {
+	user, err := s.UserService.FindUserByName(s.ctx, name)
+	if err != nil || user == nil || !user.Protected {
+		return err
+	}
+
+	preferences, err := s.PreferencesService.FindPreferences(s.ctx, user.Id)
+	if err != nil {
+		return err
+	} else if preferences.Target == "" {
+		log.Info("Not sending sign in link, user has no private target", "user", user.Name)
+		return nil
+	}
+
+	token, err := s.AuthService.CreateLoginToken(s.ctx, user.Id)
+	if err != nil {
+		return err
+	}
+
+	return s.NotificationService.CreateNotification(s.ctx, &laundryNotify.Notification{
+		UserId:  user.Id,
+		Title:   "Sign in to Laundry Notify",
+		Message: strings.TrimSuffix(s.Config.BaseURL, "/") + "/login/" + token,
+	})
+}
+
+func (s *HttpServer) handleLoginToken(c *gin.Context) {
+	userId, err := s.AuthService.RedeemLoginToken(s.ctx, c.Param("token"))
+	if err != nil {
+		c.HTML(ErrorStatusCode(laundryNotify.ErrorCode(err)), "login", gin.H{
+			"title": "Laundry Notify",
+			"error": laundryNotify.ErrorMessage(err),
+		})
+		return
+	}
+
+	user, err := s.UserService.FindUserById(s.ctx, userId)
+	if err != nil || user == nil {
+		log.Error("Error finding user for sign in link", "id", userId, "error", err)
+		c.HTML(http.StatusInternalServerError, "login", gin.H{
+			"title": "Laundry Notify",
+			"error": "Error finding user",
+		})
+		return
+	}
+
+	if err := s.setSession(c, user.Id); err != nil {
+		log.Error("Error signing in user", "user", user.Name, "error", err)
+		c.HTML(http.StatusInternalServerError, "login", gin.H{
+			"title": "Laundry Notify",
+			"error": "Error signing in",
+		})
+		return
+	}
+	log.Info("User signed in with link", "user", user.Name)
+	c.Redirect(http.StatusSeeOther, "/users/"+url.PathEscape(user.Name))
+}
+
+func (s *HttpServer) handleLogout(c *gin.Context) {
+	s.clearSession(c)
+	c.Redirect(http.StatusSeeOther, "/")
+}
+
+// handleSetPin sets or removes the user's PIN. Setting a PIN also signs the user in, so they aren't
+// immediately locked out
+func (s *HttpServer) handleSetPin(c *gin.Context) {
+	name := c.Param("name")
+	user, err := s.UserService.FindUserByName(s.ctx, name)
+	if err != nil {
+		log.Error("Error finding user by name", "error", err)
+	}
+	if user == nil {
+		c.HTML(http.StatusNotFound, "user", gin.H{
+			"title": "Laundry Notify",
+			"name":  name,
+			"error": "User not found",
+		})
+		return
+	}
+	if err := s.authorize(c, user); err != nil {
+		redirectToLogin(c, user.Name, "/users/"+url.PathEscape(user.Name))
+		return
+	}
+
+	pin := c.PostForm("pin")
+	if err := s.AuthService.SetPin(s.ctx, user.Id, pin); err != nil {
+		c.HTML(ErrorStatusCode(laundryNotify.ErrorCode(err)), "user", gin.H{
+			"title": "Laundry Notify",
+			"name":  user.Name,
+			"error": laundryNotify.ErrorMessage(err),
+		})
+		return
+	}
+
+	// Changing the PIN signs out every session, including this one, so sign the user back in
+	if pin != "" {
+		if err := s.setSession(c, user.Id); err != nil {
+			log.Error("Error signing in user", "user", user.Name, "error", err)
+		}
+		log.Info("PIN set", "user", user.Name)
+	} else {
+		log.Info("PIN removed", "user", user.Name)
+	}
+	c.Redirect(http.StatusSeeOther, "/users/"+url.PathEscape(user.Name)+"?saved=1")
+}
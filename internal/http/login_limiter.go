@@ -0,0 +1,84 @@
+package http
+
+import (
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Failed PIN attempts are counted over this window
+const loginFailureWindow = 15 * time.Minute
+
+// Failed PIN attempts allowed in the window for one user, and from one ip address across every user
+const maxUserLoginFailures = 5
+const maxIpLoginFailures = 20
+
+// loginLimiter counts failed PIN attempts, so PINs can't be guessed by trying every one. Counts are kept in
+// memory, so they start again after a restart.
+type loginLimiter struct {
+	mu       sync.Mutex
+	failures map[string][]time.Time
+}
+
+func newLoginLimiter() *loginLimiter {
+	return &loginLimiter{
+		failures: make(map[string][]time.Time),
+	}
+}
+
+func userLoginKey(userId int) string {
+	return "user:" + strconv.Itoa(userId)
+}
+
+func ipLoginKey(ip string) string {
+	return "ip:" + ip
+}
+
+// allowed reports whether there have been fewer than limit failures for the key in the window
+func (l *loginLimiter) allowed(key string, limit int) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return len(l.recent(key, time.Now())) < limit
+}
+
+// fail records a failed attempt against each of the keys
+func (l *loginLimiter) fail(keys ...string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	for _, key := range keys {
+		l.failures[key] = append(l.recent(key, now), now)
+	}
+
+	// Forget keys that haven't failed recently, so the map doesn't grow forever
+	for key := range l.failures {
+		l.recent(key, now)
+	}
+}
+
+// reset forgets the failures for the key, once the user has signed in
+func (l *loginLimiter) reset(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	delete(l.failures, key)
+}
+
+// recent drops failures older than the window, and returns the ones left
+func (l *loginLimiter) recent(key string, now time.Time) []time.Time {
+	failures := l.failures[key]
+	i := 0
+	for i < len(failures) && now.Sub(failures[i]) >= loginFailureWindow {
+		i++
+	}
+	failures = failures[i:]
+
+	if len(failures) == 0 {
+		delete(l.failures, key)
+	} else {
+		l.failures[key] = failures
+	}
+	return failures
+}
@@ -1,8 +1,11 @@
 package http
 
 import (
+	"context"
 	laundryNotify "jallier/laundry-notify"
 	"net/http"
+	"net/url"
+	"strings"
 
 	"github.com/charmbracelet/log"
 	"github.com/gin-gonic/gin"
@@ -15,148 +18,117 @@ func (s *HttpServer) registerRegisterRoutes() {
 }
 
 type RegisterRequest struct {
-	Name string `form:"name"`
-	Type string `form:"type"`
+	Name string `form:"name" json:"name"`
+	Type string `form:"type" json:"type"`
+	// Also subscribe to the next load of the appliance the load moves on to, eg the dryer after the washer
+	FollowOn bool `form:"follow_on" json:"follow_on"`
+}
+
+// Subscription is the result of registering a user's interest in an appliance
+type Subscription struct {
+	User      *laundryNotify.User
+	UserEvent *laundryNotify.UserEvent
+	// The in progress event the user was registered for. Nil if they are waiting for the next event
+	Event                *laundryNotify.Event
+	PreviouslyRegistered bool
 }
 
 func (s *HttpServer) handleRegister(c *gin.Context) {
 	var req RegisterRequest
 	c.Bind(&req)
 
-	if req.Name == "" {
+	sub, err := s.subscribe(c.Request.Context(), req, s.sessionUserId(c))
+	if laundryNotify.ErrorCode(err) == laundryNotify.EUNAUTHORIZED {
+		// Finish registering once they've signed in
+		next := url.Values{"name": {req.Name}, "type": {req.Type}}
+		if req.FollowOn {
+			next.Set("follow_on", "true")
+		}
+		redirectToLogin(c, req.Name, "/register?"+next.Encode())
+		return
+	} else if err != nil {
 		c.HTML(http.StatusOK, "registered", gin.H{
-			"error": "Name is required",
+			"error": laundryNotify.ErrorMessage(err),
 		})
 		return
 	}
 
-	if req.Type == "" || (req.Type != laundryNotify.WASHER_EVENT && req.Type != laundryNotify.DRYER_EVENT) {
-		c.HTML(http.StatusOK, "registered", gin.H{
-			"error": "Valid type is required",
-		})
-		return
+	ntfyTopic := strings.ReplaceAll(sub.User.Name, " ", "_")
+	if preferences, err := s.PreferencesService.FindPreferences(s.ctx, sub.User.Id); err != nil {
+		log.Error("Error finding preferences", "error", err)
+	} else if preferences.Target != "" {
+		ntfyTopic = preferences.Target
+	}
+
+	c.HTML(http.StatusOK, "registered", gin.H{
+		"title":                "Laundry Notify",
+		"name":                 sub.User.Name,
+		"previouslyRegistered": sub.PreviouslyRegistered,
+		"ntfyBaseTopic":        s.Config.NtfyBaseTopic,
+		"ntfyTopic":            ntfyTopic,
+		"mostRecentEvent":      sub.Event,
+		"userEventId":          sub.UserEvent.Id,
+		"followOn":             s.Appliances.Find(sub.UserEvent.FollowOn),
+	})
+}
+
+// subscribe registers the user for the current event of the requested type if one is in progress,
+// otherwise for the next one. The user is created if they don't exist yet. Protected users must be the
+// one signed in.
+func (s *HttpServer) subscribe(ctx context.Context, req RegisterRequest, sessionUserId int) (*Subscription, error) {
+	if req.Name == "" {
+		return nil, laundryNotify.Errorf(laundryNotify.EINVALID, "Name is required")
 	}
 
-	user, err := s.UserService.FindUserByName(s.ctx, req.Name)
+	appliance := s.Appliances.Find(req.Type)
+	if appliance == nil {
+		return nil, laundryNotify.Errorf(laundryNotify.EINVALID, "Valid type is required")
+	}
+	if req.FollowOn && appliance.FollowOn == "" {
+		return nil, laundryNotify.Errorf(laundryNotify.EINVALID, "%s loads don't move on to another appliance", appliance.Name)
+	}
+
+	user, err := s.UserService.FindUserByName(ctx, req.Name)
 	if err != nil {
 		log.Error("Error finding user by name", "error", err)
 	}
 	if user == nil {
 		log.Debug("User not found", "name", req.Name)
 		user = &laundryNotify.User{Name: req.Name}
-		err = s.UserService.CreateUser(s.ctx, user)
+		err = s.UserService.CreateUser(ctx, user)
 		if err != nil {
 			log.Error("Error creating user", "error", err)
-			c.HTML(http.StatusOK, "registered", gin.H{
-				"error": "Error creating user",
-			})
-			return
+			return nil, laundryNotify.Errorf(laundryNotify.EINTERNAL, "Error creating user")
 		}
 	} else {
 		log.Debug("User found", "user", user)
+		if err := authorizeUser(user, sessionUserId); err != nil {
+			return nil, err
+		}
 	}
 	log.Info("Registering user interest")
 
-	mostRecentEvent, err := s.EventService.FindMostRecentEvent(s.ctx, req.Type)
-	if err != nil {
-		log.Error("Error finding most recent event", "error", err)
-		c.HTML(http.StatusOK, "registered", gin.H{
-			"error": "Error finding most recent event",
-		})
-		return
+	followOn := ""
+	if req.FollowOn {
+		followOn = appliance.FollowOn
 	}
 
-	// If finished at isn't set, then this event is ongoing
-	var templateVars gin.H
-	if mostRecentEvent == nil || mostRecentEvent.FinishedAt.Valid {
-		templateVars = s.registerUserForNextEvent(req, user)
-	} else {
-		templateVars = s.registerUserForCurrentEvent(req, mostRecentEvent, user)
-	}
-	c.HTML(http.StatusOK, "registered", templateVars)
-}
-
-func (s *HttpServer) registerUserForNextEvent(req RegisterRequest, user *laundryNotify.User) gin.H {
-	// Event is finished
-	// First check if they have already registered for the next event
-	_, n, err := s.UserEventService.FindByUserName(s.ctx, user.Name, req.Type)
-	if err != nil {
-		log.Error("Error finding user events by name", "error", err)
-		return gin.H{
-			"error": "Error finding user events by name",
-		}
-	}
-	log.Debug("User event count", "count", n)
-	if n > 0 {
-		log.Info("User already registered for next event", "user", user)
-		return gin.H{
-			"title":                "Laundry Notify",
-			"name":                 user.Name,
-			"previouslyRegistered": true,
-			"ntfyBaseTopic":        s.Config.NtfyBaseTopic,
-		}
-	}
-	// If they haven't, register them for the next event that is created
+	// Registers them for the running event, or the next one, unless they already are
 	userEvent := &laundryNotify.UserEvent{
-		UserId: user.Id,
-		Type:   req.Type,
+		UserId:   user.Id,
+		Type:     req.Type,
+		FollowOn: followOn,
 	}
-	err = s.UserEventService.CreateUserEvent(s.ctx, userEvent)
+	event, previouslyRegistered, err := s.UserEventService.Subscribe(ctx, userEvent)
 	if err != nil {
-		log.Error("Error creating user event", "error", err)
-		return gin.H{
-			"error": "Error creating user event",
-		}
+		log.Error("Error registering user", "error", err)
+		return nil, laundryNotify.Errorf(laundryNotify.EINTERNAL, "Error registering user")
 	}
-
-	return gin.H{
-		"title":                "Laundry Notify",
-		"name":                 user.Name,
-		"previouslyRegistered": false,
-		"ntfyBaseTopic":        s.Config.NtfyBaseTopic,
+	if previouslyRegistered {
+		log.Info("User already registered", "user", user, "event", event)
+	} else {
+		log.Info("User registered", "user", user, "event", event)
 	}
-}
 
-func (s *HttpServer) registerUserForCurrentEvent(req RegisterRequest, mostRecentEvent *laundryNotify.Event, user *laundryNotify.User) gin.H {
-	log.Debug("Event is ongoing", "event", mostRecentEvent)
-	// Event is ongoing
-	// Check if they are already registered for this event
-	_, n, err := s.UserEventService.FindByUserName(s.ctx, user.Name, req.Type)
-	if err != nil {
-		log.Error("Error finding user events by name", "error", err)
-		return gin.H{
-			"error": "Error finding user events by name",
-		}
-	}
-	if n > 0 {
-		log.Info("User already registered for this event", "user", user)
-		return gin.H{
-			"title":                "Laundry Notify",
-			"name":                 user.Name,
-			"previouslyRegistered": true,
-			"ntfyBaseTopic":        s.Config.NtfyBaseTopic,
-			"mostReventEvent":      mostRecentEvent,
-		}
-	}
-	// If not, register for it
-	userEvent := &laundryNotify.UserEvent{
-		UserId:  user.Id,
-		Type:    req.Type,
-		EventId: mostRecentEvent.Id,
-	}
-	err = s.UserEventService.CreateUserEvent(s.ctx, userEvent)
-	if err != nil {
-		log.Error("Error creating user event", "error", err)
-		return gin.H{
-			"error": "Error creating user event",
-		}
-	}
-	log.Info("User registered for ongoing event", "user", user, "event", mostRecentEvent)
-	return gin.H{
-		"title":                "Laundry Notify",
-		"name":                 user.Name,
-		"previouslyRegistered": false,
-		"ntfyBaseTopic":        s.Config.NtfyBaseTopic,
-		"mostReventEvent":      mostRecentEvent,
-	}
+	return &Subscription{User: user, UserEvent: userEvent, Event: event, PreviouslyRegistered: previouslyRegistered}, nil
 }
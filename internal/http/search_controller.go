@@ -25,13 +25,13 @@ func (s *HttpServer) handleSearch(c *gin.Context) {
 		log.Error("Error finding most recent users", "error", err)
 	}
 
-	// Stupid goview workaround. Or maybe stupid me :thinking:
-	templateName := "partials/search-washer.html"
-	if req.Type == "dryer" {
-		templateName = "partials/search-dryer.html"
+	if s.Appliances.Find(req.Type) == nil {
+		c.Status(http.StatusBadRequest)
+		return
 	}
 
-	c.HTML(http.StatusOK, templateName, gin.H{
+	c.HTML(http.StatusOK, "partials/search.html", gin.H{
 		"users": users,
+		"type":  req.Type,
 	})
 }
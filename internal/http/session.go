@@ -0,0 +1,152 @@
+package http
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	laundryNotify "jallier/laundry-notify"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/gin-gonic/gin"
+)
+
+const sessionCookie = "laundry_session"
+const sessionLifetime = 30 * 24 * time.Hour
+
+// ensureSessionSecret generates a random secret if one wasn't configured. Sessions then only last until
+// the server restarts
+func (s *HttpServer) ensureSessionSecret() {
+	if len(s.Config.SessionSecret) > 0 {
+		return
+	}
+	log.Warn("No session secret set, users will need to sign in again after a restart")
+	s.Config.SessionSecret = make([]byte, 32)
+	if _, err := rand.Read(s.Config.SessionSecret); err != nil {
+		panic(err)
+	}
+}
+
+// signSession returns a cookie value of `userId.credentialVersion.expiresAt.signature`
+func (s *HttpServer) signSession(userId int, credentialVersion int, expiresAt time.Time) string {
+	payload := fmt.Sprintf("%d.%d.%d", userId, credentialVersion, expiresAt.Unix())
+	return payload + "." + s.sessionSignature(payload)
+}
+
+func (s *HttpServer) sessionSignature(payload string) string {
+	mac := hmac.New(sha256.New, s.Config.SessionSecret)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// sessionUserId returns the id of the signed in user, or zero if nobody is signed in
+func (s *HttpServer) sessionUserId(c *gin.Context) int {
+	value, err := c.Cookie(sessionCookie)
+	if err != nil {
+		return 0
+	}
+
+	i := strings.LastIndex(value, ".")
+	if i < 0 {
+		return 0
+	}
+	payload, signature := value[:i], value[i+1:]
+	if !hmac.Equal([]byte(signature), []byte(s.sessionSignature(payload))) {
+		return 0
+	}
+
+	parts := strings.Split(payload, ".")
+	if len(parts) != 3 {
+		return 0
+	}
+	expires, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		return 0
+	}
+	id, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0
+	}
+
+	// Changing the PIN changes the credential version, which signs out sessions from before the change
+	version, err := s.AuthService.CredentialVersion(c.Request.Context(), id)
+	if err != nil {
+		if laundryNotify.ErrorCode(err) != laundryNotify.ENOTFOUND {
+			log.Error("Error finding credential version", "id", id, "error", err)
+		}
+		return 0
+	}
+	if parts[1] != strconv.Itoa(version) {
+		return 0
+	}
+	return id
+}
+
+// setSession signs the user in
+func (s *HttpServer) setSession(c *gin.Context, userId int) error {
+	version, err := s.AuthService.CredentialVersion(c.Request.Context(), userId)
+	if err != nil {
+		return err
+	}
+
+	expiresAt := time.Now().Add(sessionLifetime)
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    s.signSession(userId, version, expiresAt),
+		Path:     "/",
+		Expires:  expiresAt,
+		HttpOnly: true,
+		Secure:   isHttps(c),
+		SameSite: http.SameSiteLaxMode,
+	})
+	return nil
+}
+
+// clearSession signs the user out
+func (s *HttpServer) clearSession(c *gin.Context) {
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   isHttps(c),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// authorize returns EUNAUTHORIZED if the user is protected and isn't the one signed in
+func (s *HttpServer) authorize(c *gin.Context, user *laundryNotify.User) error {
+	return authorizeUser(user, s.sessionUserId(c))
+}
+
+func authorizeUser(user *laundryNotify.User, sessionUserId int) error {
+	if !user.Protected || user.Id == sessionUserId {
+		return nil
+	}
+	return laundryNotify.Errorf(laundryNotify.EUNAUTHORIZED, "Sign in as %s to continue.", user.Name)
+}
+
+// redirectToLogin sends the browser to the sign in page, returning to next once signed in
+func redirectToLogin(c *gin.Context, name string, next string) {
+	query := url.Values{"name": {name}, "next": {next}}
+	c.Redirect(http.StatusSeeOther, "/login?"+query.Encode())
+}
+
+func isHttps(c *gin.Context) bool {
+	return c.Request.TLS != nil || c.GetHeader("X-Forwarded-Proto") == "https"
+}
+
+// safeRedirect returns next if it is a path on this site, otherwise the fallback
+func safeRedirect(next string, fallback string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return fallback
+	}
+	return next
+}
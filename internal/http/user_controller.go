@@ -0,0 +1,504 @@
+package http
+
+import (
+	"context"
+	"database/sql"
+	laundryNotify "jallier/laundry-notify"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/gin-gonic/gin"
+)
+
+func (s *HttpServer) registerUserRoutes() {
+	s.router.GET("/users/:name", s.handleUser)
+	s.router.POST("/subscriptions/:id/cancel", s.handleCancelSubscription)
+	s.router.POST("/users/:name/events/:id/collected", s.handleCollected)
+	s.router.POST("/users/:name/preferences", s.handleSavePreferences)
+	s.router.POST("/users/:name/standing", s.handleCreateStandingSubscription)
+	s.router.POST("/users/:name/standing/:id/delete", s.handleDeleteStandingSubscription)
+}
+
+// PreferencesRequest is the form or json body used to save a user's preferences
+type PreferencesRequest struct {
+	Channel    string `form:"channel" json:"channel"`
+	Target     string `form:"target" json:"target"`
+	QuietStart string `form:"quiet_start" json:"quiet_start"`
+	QuietEnd   string `form:"quiet_end" json:"quiet_end"`
+	Priority   string `form:"priority" json:"priority"`
+	Language   string `form:"language" json:"language"`
+}
+
+// StandingSubscriptionRequest is the form or json body used to create a standing subscription
+type StandingSubscriptionRequest struct {
+	Type     string `form:"type" json:"type" binding:"required"`
+	Schedule string `form:"schedule" json:"schedule" binding:"required"`
+	// Days of the week for a window schedule, with Sunday as 0. Empty means every day
+	Weekdays    []int  `form:"weekdays" json:"weekdays"`
+	WindowStart string `form:"window_start" json:"window_start"`
+	WindowEnd   string `form:"window_end" json:"window_end"`
+	// Number of cycles for a count schedule
+	Count int `form:"count" json:"count"`
+	// Either a date, which expires at the end of that day, or an RFC3339 timestamp
+	ExpiresAt string `form:"expires_at" json:"expires_at" binding:"required"`
+}
+
+// standingSubscription is a standing subscription along with its appliance, for display
+type standingSubscription struct {
+	StandingSubscription *laundryNotify.StandingSubscription
+	Appliance            *laundryNotify.Appliance
+}
+
+// userSubscription is a user event along with its appliance and event, for display
+type userSubscription struct {
+	UserEvent *laundryNotify.UserEvent
+	Appliance *laundryNotify.Appliance
+	Event     *laundryNotify.Event
+	// The appliance the load will be followed on to, if any
+	FollowOn *laundryNotify.Appliance
+}
+
+// uncollectedEvent is a finished event the user still has reminders pending for
+type uncollectedEvent struct {
+	Appliance *laundryNotify.Appliance
+	Event     *laundryNotify.Event
+}
+
+func (s *HttpServer) handleUser(c *gin.Context) {
+	name := c.Param("name")
+	user, err := s.UserService.FindUserByName(s.ctx, name)
+	if err != nil {
+		log.Error("Error finding user by name", "error", err)
+	}
+	if user == nil {
+		c.HTML(http.StatusNotFound, "user", gin.H{
+			"title": "Laundry Notify",
+			"name":  name,
+			"error": "User not found",
+		})
+		return
+	}
+
+	if err := s.authorize(c, user); err != nil {
+		redirectToLogin(c, user.Name, "/users/"+url.PathEscape(user.Name))
+		return
+	}
+
+	userEvents, _, err := s.UserEventService.FindUserEvents(s.ctx, laundryNotify.UserEventFilter{
+		UserId: &user.Id,
+		Active: true,
+	})
+	if err != nil {
+		log.Error("Error finding user events", "error", err)
+		c.HTML(http.StatusOK, "user", gin.H{
+			"title": "Laundry Notify",
+			"name":  user.Name,
+			"error": "Error finding subscriptions",
+		})
+		return
+	}
+
+	subscriptions := make([]userSubscription, 0, len(userEvents))
+	for _, userEvent := range userEvents {
+		sub := userSubscription{
+			UserEvent: userEvent,
+			Appliance: s.Appliances.Find(userEvent.Type),
+			FollowOn:  s.Appliances.Find(userEvent.FollowOn),
+		}
+		if userEvent.EventId > 0 {
+			if sub.Event, err = s.EventService.FindEventById(s.ctx, userEvent.EventId); err != nil {
+				log.Error("Error finding event", "id", userEvent.EventId, "error", err)
+			}
+		}
+		subscriptions = append(subscriptions, sub)
+	}
+
+	uncollected, err := s.findUncollectedEvents(user.Id)
+	if err != nil {
+		log.Error("Error finding uncollected events", "error", err)
+	}
+
+	standing, err := s.findStandingSubscriptions(user.Id)
+	if err != nil {
+		log.Error("Error finding standing subscriptions", "error", err)
+	}
+
+	preferences, err := s.PreferencesService.FindPreferences(s.ctx, user.Id)
+	if err != nil {
+		log.Error("Error finding preferences", "error", err)
+	}
+
+	c.HTML(http.StatusOK, "user", gin.H{
+		"title":         "Laundry Notify",
+		"name":          user.Name,
+		"subscriptions": subscriptions,
+		"uncollected":   uncollected,
+		"standing":      standing,
+		"appliances":    s.Appliances,
+		"cancelled":     c.Query("cancelled") != "",
+		"collected":     c.Query("collected") != "",
+		"saved":         c.Query("saved") != "",
+		"subscribed":    c.Query("subscribed") != "",
+		"preferences":   preferences,
+		"notifiers":     s.Config.Notifiers,
+		"protected":     user.Protected,
+		"signedIn":      s.sessionUserId(c) == user.Id,
+		"ntfyBaseTopic": s.Config.NtfyBaseTopic,
+	})
+}
+
+func (s *HttpServer) handleCancelSubscription(c *gin.Context) {
+	id, err := paramInt(c, "id")
+	if err != nil {
+		c.HTML(http.StatusBadRequest, "user", gin.H{
+			"title": "Laundry Notify",
+			"error": laundryNotify.ErrorMessage(err),
+		})
+		return
+	}
+
+	userEvent, err := s.UserEventService.FindUserEventById(s.ctx, id)
+	if err != nil {
+		c.HTML(ErrorStatusCode(laundryNotify.ErrorCode(err)), "user", gin.H{
+			"title": "Laundry Notify",
+			"error": laundryNotify.ErrorMessage(err),
+		})
+		return
+	}
+	user, err := s.UserService.FindUserById(s.ctx, userEvent.UserId)
+	if err != nil || user == nil {
+		log.Error("Error finding user for user event", "id", id, "error", err)
+		c.HTML(http.StatusInternalServerError, "user", gin.H{
+			"title": "Laundry Notify",
+			"error": "Error finding user",
+		})
+		return
+	}
+
+	if err := s.authorize(c, user); err != nil {
+		redirectToLogin(c, user.Name, "/users/"+url.PathEscape(user.Name))
+		return
+	}
+
+	if err := s.UserEventService.DeleteUserEvent(s.ctx, id); err != nil {
+		log.Error("Error cancelling user event", "id", id, "error", err)
+		c.HTML(ErrorStatusCode(laundryNotify.ErrorCode(err)), "user", gin.H{
+			"title": "Laundry Notify",
+			"name":  user.Name,
+			"error": laundryNotify.ErrorMessage(err),
+		})
+		return
+	}
+	log.Info("User event cancelled", "user", user.Name, "id", id)
+
+	c.Redirect(http.StatusSeeOther, "/users/"+url.PathEscape(user.Name)+"?cancelled=1")
+}
+
+// findUncollectedEvents returns the events the user has pending reminders for, in the order they're due
+func (s *HttpServer) findUncollectedEvents(userId int) ([]uncollectedEvent, error) {
+	reminders, _, err := s.ReminderService.FindReminders(s.ctx, laundryNotify.ReminderFilter{
+		UserId:  &userId,
+		Pending: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[int]bool)
+	uncollected := make([]uncollectedEvent, 0)
+	for _, reminder := range reminders {
+		if seen[reminder.EventId] {
+			continue
+		}
+		seen[reminder.EventId] = true
+
+		event, err := s.EventService.FindEventById(s.ctx, reminder.EventId)
+		if err != nil {
+			return nil, err
+		}
+		uncollected = append(uncollected, uncollectedEvent{Appliance: s.Appliances.Find(event.Type), Event: event})
+	}
+	return uncollected, nil
+}
+
+// handleCollected acknowledges that the user has collected their laundry, stopping any further reminders
+func (s *HttpServer) handleCollected(c *gin.Context) {
+	name := c.Param("name")
+	id, err := paramInt(c, "id")
+	if err != nil {
+		c.HTML(http.StatusBadRequest, "user", gin.H{
+			"title": "Laundry Notify",
+			"name":  name,
+			"error": laundryNotify.ErrorMessage(err),
+		})
+		return
+	}
+
+	user, err := s.UserService.FindUserByName(s.ctx, name)
+	if err != nil {
+		log.Error("Error finding user by name", "error", err)
+	}
+	if user == nil {
+		c.HTML(http.StatusNotFound, "user", gin.H{
+			"title": "Laundry Notify",
+			"name":  name,
+			"error": "User not found",
+		})
+		return
+	}
+
+	if err := s.authorize(c, user); err != nil {
+		redirectToLogin(c, user.Name, "/users/"+url.PathEscape(user.Name))
+		return
+	}
+
+	n, err := s.ReminderService.AcknowledgeReminders(s.ctx, user.Id, id)
+	if err != nil {
+		log.Error("Error acknowledging reminders", "user", user.Name, "event_id", id, "error", err)
+		c.HTML(ErrorStatusCode(laundryNotify.ErrorCode(err)), "user", gin.H{
+			"title": "Laundry Notify",
+			"name":  user.Name,
+			"error": laundryNotify.ErrorMessage(err),
+		})
+		return
+	}
+	log.Info("Laundry collected", "user", user.Name, "event_id", id, "reminders_cancelled", n)
+
+	c.Redirect(http.StatusSeeOther, "/users/"+url.PathEscape(user.Name)+"?collected=1")
+}
+
+// savePreferences replaces the user's preferences, checking the channel is one that is enabled
+func (s *HttpServer) savePreferences(ctx context.Context, user *laundryNotify.User, req PreferencesRequest) (*laundryNotify.Preferences, error) {
+	if req.Channel != "" && !slices.Contains(s.Config.Notifiers, req.Channel) {
+		return nil, laundryNotify.Errorf(laundryNotify.EINVALID, "Notifier not enabled: %s", req.Channel)
+	}
+	if target := strings.TrimSpace(req.Target); req.Channel == "webhook" && target != "" && !laundryNotify.WebhookTargetAllowed(target, s.Config.WebhookHosts) {
+		return nil, laundryNotify.Errorf(laundryNotify.EINVALID, "Webhook url must be on one of these hosts: %s", strings.Join(s.Config.WebhookHosts, ", "))
+	}
+
+	preferences := &laundryNotify.Preferences{
+		UserId:     user.Id,
+		Channel:    req.Channel,
+		Target:     strings.TrimSpace(req.Target),
+		QuietStart: req.QuietStart,
+		QuietEnd:   req.QuietEnd,
+		Priority:   req.Priority,
+		Language:   strings.TrimSpace(req.Language),
+	}
+	if err := s.PreferencesService.SavePreferences(ctx, preferences); err != nil {
+		return nil, err
+	}
+	return preferences, nil
+}
+
+func (s *HttpServer) handleSavePreferences(c *gin.Context) {
+	name := c.Param("name")
+	user, err := s.UserService.FindUserByName(s.ctx, name)
+	if err != nil {
+		log.Error("Error finding user by name", "error", err)
+	}
+	if user == nil {
+		c.HTML(http.StatusNotFound, "user", gin.H{
+			"title": "Laundry Notify",
+			"name":  name,
+			"error": "User not found",
+		})
+		return
+	}
+
+	if err := s.authorize(c, user); err != nil {
+		redirectToLogin(c, user.Name, "/users/"+url.PathEscape(user.Name))
+		return
+	}
+
+	var req PreferencesRequest
+	if err := c.ShouldBind(&req); err != nil {
+		c.HTML(http.StatusBadRequest, "user", gin.H{
+			"title": "Laundry Notify",
+			"name":  user.Name,
+			"error": "Invalid preferences",
+		})
+		return
+	}
+
+	if _, err := s.savePreferences(c.Request.Context(), user, req); err != nil {
+		log.Error("Error saving preferences", "user", user.Name, "error", err)
+		c.HTML(ErrorStatusCode(laundryNotify.ErrorCode(err)), "user", gin.H{
+			"title": "Laundry Notify",
+			"name":  user.Name,
+			"error": laundryNotify.ErrorMessage(err),
+		})
+		return
+	}
+	log.Info("Preferences saved", "user", user.Name)
+
+	c.Redirect(http.StatusSeeOther, "/users/"+url.PathEscape(user.Name)+"?saved=1")
+}
+
+// findStandingSubscriptions returns the user's standing subscriptions that haven't expired or run out
+func (s *HttpServer) findStandingSubscriptions(userId int) ([]standingSubscription, error) {
+	subscriptions, _, err := s.StandingSubscriptionService.FindStandingSubscriptions(s.ctx, laundryNotify.StandingSubscriptionFilter{
+		UserId: &userId,
+		Active: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	standing := make([]standingSubscription, 0, len(subscriptions))
+	for _, subscription := range subscriptions {
+		standing = append(standing, standingSubscription{
+			StandingSubscription: subscription,
+			Appliance:            s.Appliances.Find(subscription.Type),
+		})
+	}
+	return standing, nil
+}
+
+// createStandingSubscription creates a standing subscription for the user, checking the appliance exists
+func (s *HttpServer) createStandingSubscription(ctx context.Context, user *laundryNotify.User, req StandingSubscriptionRequest) (*laundryNotify.StandingSubscription, error) {
+	if s.Appliances.Find(req.Type) == nil {
+		return nil, laundryNotify.Errorf(laundryNotify.EINVALID, "Unknown appliance: %s", req.Type)
+	}
+
+	subscription := &laundryNotify.StandingSubscription{
+		UserId:   user.Id,
+		Type:     req.Type,
+		Schedule: req.Schedule,
+	}
+	switch req.Schedule {
+	case laundryNotify.SCHEDULE_WINDOW:
+		for _, day := range req.Weekdays {
+			subscription.Weekdays = append(subscription.Weekdays, time.Weekday(day))
+		}
+		subscription.WindowStart = req.WindowStart
+		subscription.WindowEnd = req.WindowEnd
+	case laundryNotify.SCHEDULE_COUNT:
+		subscription.Remaining = req.Count
+	}
+
+	if expiresAt, err := time.ParseInLocation(time.DateOnly, req.ExpiresAt, time.Local); err == nil {
+		endOfDay := time.Date(expiresAt.Year(), expiresAt.Month(), expiresAt.Day(), 23, 59, 59, 0, time.Local)
+		subscription.ExpiresAt = sql.NullTime{Time: endOfDay, Valid: true}
+	} else if expiresAt, err := time.Parse(time.RFC3339, req.ExpiresAt); err == nil {
+		subscription.ExpiresAt = sql.NullTime{Time: expiresAt, Valid: true}
+	} else {
+		return nil, laundryNotify.Errorf(laundryNotify.EINVALID, "Expiry must be a date or an RFC3339 timestamp.")
+	}
+
+	if err := s.StandingSubscriptionService.CreateStandingSubscription(ctx, subscription); err != nil {
+		return nil, err
+	}
+	return subscription, nil
+}
+
+// findUserStandingSubscription returns the standing subscription, if it belongs to the user
+func (s *HttpServer) findUserStandingSubscription(ctx context.Context, user *laundryNotify.User, id int) (*laundryNotify.StandingSubscription, error) {
+	subscriptions, _, err := s.StandingSubscriptionService.FindStandingSubscriptions(ctx, laundryNotify.StandingSubscriptionFilter{
+		Id:     &id,
+		UserId: &user.Id,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(subscriptions) == 0 {
+		return nil, laundryNotify.Errorf(laundryNotify.ENOTFOUND, "Standing subscription not found: %d", id)
+	}
+	return subscriptions[0], nil
+}
+
+func (s *HttpServer) handleCreateStandingSubscription(c *gin.Context) {
+	name := c.Param("name")
+	user, err := s.UserService.FindUserByName(s.ctx, name)
+	if err != nil {
+		log.Error("Error finding user by name", "error", err)
+	}
+	if user == nil {
+		c.HTML(http.StatusNotFound, "user", gin.H{
+			"title": "Laundry Notify",
+			"name":  name,
+			"error": "User not found",
+		})
+		return
+	}
+
+	if err := s.authorize(c, user); err != nil {
+		redirectToLogin(c, user.Name, "/users/"+url.PathEscape(user.Name))
+		return
+	}
+
+	var req StandingSubscriptionRequest
+	if err := c.ShouldBind(&req); err != nil {
+		c.HTML(http.StatusBadRequest, "user", gin.H{
+			"title": "Laundry Notify",
+			"name":  user.Name,
+			"error": "Invalid standing subscription",
+		})
+		return
+	}
+
+	subscription, err := s.createStandingSubscription(c.Request.Context(), user, req)
+	if err != nil {
+		log.Error("Error creating standing subscription", "user", user.Name, "error", err)
+		c.HTML(ErrorStatusCode(laundryNotify.ErrorCode(err)), "user", gin.H{
+			"title": "Laundry Notify",
+			"name":  user.Name,
+			"error": laundryNotify.ErrorMessage(err),
+		})
+		return
+	}
+	log.Info("Standing subscription created", "user", user.Name, "id", subscription.Id, "type", subscription.Type, "schedule", subscription.Schedule)
+
+	c.Redirect(http.StatusSeeOther, "/users/"+url.PathEscape(user.Name)+"?subscribed=1")
+}
+
+func (s *HttpServer) handleDeleteStandingSubscription(c *gin.Context) {
+	name := c.Param("name")
+	id, err := paramInt(c, "id")
+	if err != nil {
+		c.HTML(http.StatusBadRequest, "user", gin.H{
+			"title": "Laundry Notify",
+			"name":  name,
+			"error": laundryNotify.ErrorMessage(err),
+		})
+		return
+	}
+
+	user, err := s.UserService.FindUserByName(s.ctx, name)
+	if err != nil {
+		log.Error("Error finding user by name", "error", err)
+	}
+	if user == nil {
+		c.HTML(http.StatusNotFound, "user", gin.H{
+			"title": "Laundry Notify",
+			"name":  name,
+			"error": "User not found",
+		})
+		return
+	}
+
+	if err := s.authorize(c, user); err != nil {
+		redirectToLogin(c, user.Name, "/users/"+url.PathEscape(user.Name))
+		return
+	}
+
+	if _, err = s.findUserStandingSubscription(c.Request.Context(), user, id); err == nil {
+		err = s.StandingSubscriptionService.DeleteStandingSubscription(s.ctx, id)
+	}
+	if err != nil {
+		log.Error("Error deleting standing subscription", "user", user.Name, "id", id, "error", err)
+		c.HTML(ErrorStatusCode(laundryNotify.ErrorCode(err)), "user", gin.H{
+			"title": "Laundry Notify",
+			"name":  user.Name,
+			"error": laundryNotify.ErrorMessage(err),
+		})
+		return
+	}
+	log.Info("Standing subscription deleted", "user", user.Name, "id", id)
+
+	c.Redirect(http.StatusSeeOther, "/users/"+url.PathEscape(user.Name)+"?cancelled=1")
+}
@@ -0,0 +1,96 @@
+package inmem
+
+import (
+	laundryNotify "jallier/laundry-notify"
+	"sync"
+
+	"github.com/charmbracelet/log"
+)
+
+// SubscriptionBufferSize is the number of updates buffered for each subscriber before they are dropped
+const SubscriptionBufferSize = 16
+
+// Ensure type implements interface.
+var _ laundryNotify.EventBus = (*EventBus)(nil)
+
+// EventBus is an in-process fan out of appliance updates
+type EventBus struct {
+	mu            sync.Mutex
+	subscriptions map[*Subscription]struct{}
+}
+
+func NewEventBus() *EventBus {
+	return &EventBus{
+		subscriptions: make(map[*Subscription]struct{}),
+	}
+}
+
+// Publish sends the update to every subscriber. Subscribers that aren't keeping up miss the update
+// rather than blocking the publisher.
+func (b *EventBus) Publish(update laundryNotify.ApplianceUpdate) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for sub := range b.subscriptions {
+		select {
+		case sub.c <- update:
+		default:
+			log.Warn("event bus subscriber is full, dropping update")
+		}
+	}
+}
+
+func (b *EventBus) Subscribe() laundryNotify.Subscription {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	sub := &Subscription{
+		bus: b,
+		c:   make(chan laundryNotify.ApplianceUpdate, SubscriptionBufferSize),
+	}
+	b.subscriptions[sub] = struct{}{}
+	return sub
+}
+
+// Close closes every subscription
+func (b *EventBus) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for sub := range b.subscriptions {
+		sub.close()
+	}
+}
+
+func (b *EventBus) unsubscribe(sub *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	sub.close()
+}
+
+// close removes the subscription and closes its channel. The bus lock must be held
+func (s *Subscription) close() {
+	if _, ok := s.bus.subscriptions[s]; !ok {
+		return
+	}
+	delete(s.bus.subscriptions, s)
+	close(s.c)
+}
+
+var _ laundryNotify.Subscription = (*Subscription)(nil)
+
+// Subscription is a single subscriber to an EventBus
+type Subscription struct {
+	bus *EventBus
+	c   chan laundryNotify.ApplianceUpdate
+}
+
+// C returns the channel updates are received on. It is closed when the subscription is closed
+func (s *Subscription) C() <-chan laundryNotify.ApplianceUpdate {
+	return s.c
+}
+
+func (s *Subscription) Close() {
+	s.bus.unsubscribe(s)
+}
@@ -0,0 +1,275 @@
+package mqtt
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	laundryNotify "jallier/laundry-notify"
+	"time"
+
+	"github.com/charmbracelet/log"
+)
+
+const DefaultDiscoveryPrefix = "homeassistant"
+const DefaultStateTopic = "laundry-notify"
+const DefaultStateInterval = time.Minute
+
+const APPLIANCE_RUNNING = "running"
+const APPLIANCE_IDLE = "idle"
+
+// HomeAssistantPublisher publishes Home Assistant MQTT discovery configs for each appliance, and keeps a
+// retained state topic per appliance up to date, so HA gets sensors without any templating on its side
+type HomeAssistantPublisher struct {
+	mqtt             *MQTTManager
+	appliances       laundryNotify.Appliances
+	eventService     laundryNotify.EventService
+	userEventService laundryNotify.UserEventService
+	eventBus         laundryNotify.EventBus
+
+	// Topic prefix HA watches for discovery configs
+	DiscoveryPrefix string
+	// Base topic for appliance state. Each appliance is published to <StateTopic>/<id>/state
+	StateTopic string
+	// How often state is republished regardless of updates, so subscriber counts stay current
+	Interval time.Duration
+
+	ctx    context.Context
+	cancel func()
+	done   chan struct{}
+}
+
+func NewHomeAssistantPublisher(
+	mqtt *MQTTManager,
+	appliances laundryNotify.Appliances,
+	eventService laundryNotify.EventService,
+	userEventService laundryNotify.UserEventService,
+	eventBus laundryNotify.EventBus,
+) *HomeAssistantPublisher {
+	p := &HomeAssistantPublisher{
+		mqtt:             mqtt,
+		appliances:       appliances,
+		eventService:     eventService,
+		userEventService: userEventService,
+		eventBus:         eventBus,
+		DiscoveryPrefix:  DefaultDiscoveryPrefix,
+		StateTopic:       DefaultStateTopic,
+		Interval:         DefaultStateInterval,
+		done:             make(chan struct{}),
+	}
+	p.ctx, p.cancel = context.WithCancel(context.Background())
+	return p
+}
+
+// Open republishes appliance state in a background goroutine whenever an event starts or finishes
+func (p *HomeAssistantPublisher) Open() {
+	sub := p.eventBus.Subscribe()
+	go func() {
+		defer close(p.done)
+		defer sub.Close()
+
+		ticker := time.NewTicker(p.Interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case update, ok := <-sub.C():
+				if !ok {
+					return
+				}
+				if appliance := p.appliances.Find(update.Event.Type); appliance != nil {
+					p.PublishState(appliance)
+				}
+			case <-ticker.C:
+				p.publishAllState()
+			case <-p.ctx.Done():
+				return
+			}
+		}
+	}()
+	log.Debug("home assistant publisher started", "discovery_prefix", p.DiscoveryPrefix, "state_topic", p.StateTopic)
+}
+
+// Close stops republishing state
+func (p *HomeAssistantPublisher) Close() error {
+	p.cancel()
+	<-p.done
+	return nil
+}
+
+// PublishDiscovery publishes a retained discovery config for each appliance sensor, followed by the current
+// state of each appliance. It should be called whenever the connection to the broker is established
+func (p *HomeAssistantPublisher) PublishDiscovery() error {
+	for _, appliance := range p.appliances {
+		for _, entity := range p.entities(appliance) {
+			payload, err := json.Marshal(entity.config)
+			if err != nil {
+				return err
+			}
+			topic := fmt.Sprintf("%s/%s/laundry_notify/%s/config", p.DiscoveryPrefix, entity.component, entity.config.UniqueId)
+			if err := p.mqtt.Publish(topic, payload, true); err != nil {
+				return err
+			}
+		}
+	}
+	log.Debug("home assistant discovery published", "appliances", len(p.appliances))
+
+	p.publishAllState()
+	return nil
+}
+
+// PublishState publishes the current state of the appliance to its retained state topic
+func (p *HomeAssistantPublisher) PublishState(appliance *laundryNotify.Appliance) error {
+	state, err := p.state(appliance)
+	if err != nil {
+		log.Error("Error finding appliance state", "type", appliance.Id, "error", err)
+		return err
+	}
+
+	payload, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return p.mqtt.Publish(p.stateTopic(appliance), payload, true)
+}
+
+func (p *HomeAssistantPublisher) publishAllState() {
+	for _, appliance := range p.appliances {
+		p.PublishState(appliance)
+	}
+}
+
+func (p *HomeAssistantPublisher) stateTopic(appliance *laundryNotify.Appliance) string {
+	return fmt.Sprintf("%s/%s/state", p.StateTopic, appliance.Id)
+}
+
+// applianceState is the payload published to an appliance's state topic. Times are null when unknown
+type applianceState struct {
+	// Either APPLIANCE_RUNNING or APPLIANCE_IDLE
+	State             string     `json:"state"`
+	StartedAt         *time.Time `json:"started_at"`
+	EstimatedFinishAt *time.Time `json:"estimated_finish_at"`
+	LastFinishedAt    *time.Time `json:"last_finished_at"`
+	// Users waiting for the current cycle, or the next one if the appliance is idle
+	Subscribers int `json:"subscribers"`
+}
+
+func (p *HomeAssistantPublisher) state(appliance *laundryNotify.Appliance) (*applianceState, error) {
+	state := &applianceState{State: APPLIANCE_IDLE}
+
+	event, err := p.eventService.FindMostRecentEvent(p.ctx, appliance.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	if event != nil && !event.FinishedAt.Valid {
+		state.State = APPLIANCE_RUNNING
+		startedAt := event.StartedAt.Time.UTC()
+		state.StartedAt = &startedAt
+
+		stats, err := p.eventService.FindEventStats(p.ctx, appliance.Id, event.StartedAt.Time)
+		if err != nil {
+			return nil, err
+		}
+		if estimate, ok := stats.EstimatedFinish(event.StartedAt.Time); ok {
+			estimate = estimate.UTC()
+			state.EstimatedFinishAt = &estimate
+		}
+
+		// The last finish is from the cycle before the one running now
+		events, _, err := p.eventService.FindEvents(p.ctx, laundryNotify.EventFilter{
+			Type:          &appliance.Id,
+			StartedBefore: event.StartedAt.Time,
+			OrderBy:       []string{"started_at DESC"},
+			Limit:         1,
+		})
+		if err != nil {
+			return nil, err
+		}
+		if len(events) > 0 {
+			event = events[0]
+		}
+	}
+
+	if event != nil && event.FinishedAt.Valid {
+		finishedAt := event.FinishedAt.Time.UTC()
+		state.LastFinishedAt = &finishedAt
+	}
+
+	_, n, err := p.userEventService.FindUserEvents(p.ctx, laundryNotify.UserEventFilter{
+		Type:   &appliance.Id,
+		Active: true,
+		Limit:  1,
+	})
+	if err != nil {
+		return nil, err
+	}
+	state.Subscribers = n
+
+	return state, nil
+}
+
+// discoveryConfig is the HA MQTT discovery payload for a single entity
+type discoveryConfig struct {
+	Name              string          `json:"name"`
+	UniqueId          string          `json:"unique_id"`
+	StateTopic        string          `json:"state_topic"`
+	ValueTemplate     string          `json:"value_template"`
+	AvailabilityTopic string          `json:"availability_topic,omitempty"`
+	DeviceClass       string          `json:"device_class,omitempty"`
+	StateClass        string          `json:"state_class,omitempty"`
+	Icon              string          `json:"icon,omitempty"`
+	Device            discoveryDevice `json:"device"`
+}
+
+type discoveryDevice struct {
+	Identifiers  []string `json:"identifiers"`
+	Name         string   `json:"name"`
+	Manufacturer string   `json:"manufacturer"`
+}
+
+type discoveryEntity struct {
+	// HA component, eg sensor or binary_sensor
+	component string
+	config    discoveryConfig
+}
+
+// entities returns the HA entities for an appliance. They all read from the appliance's state topic, and
+// show as unavailable while the service is offline
+func (p *HomeAssistantPublisher) entities(appliance *laundryNotify.Appliance) []discoveryEntity {
+	device := discoveryDevice{
+		Identifiers:  []string{"laundry_notify_" + appliance.Id},
+		Name:         appliance.Name,
+		Manufacturer: "laundry-notify",
+	}
+	entity := func(component string, key string, name string, valueTemplate string) discoveryEntity {
+		return discoveryEntity{
+			component: component,
+			config: discoveryConfig{
+				Name:              name,
+				UniqueId:          fmt.Sprintf("laundry_notify_%s_%s", appliance.Id, key),
+				StateTopic:        p.stateTopic(appliance),
+				ValueTemplate:     valueTemplate,
+				AvailabilityTopic: p.mqtt.AvailabilityTopic,
+				Device:            device,
+			},
+		}
+	}
+
+	running := entity("binary_sensor", "running", "Running", "{{ 'ON' if value_json.state == '"+APPLIANCE_RUNNING+"' else 'OFF' }}")
+	running.config.DeviceClass = "running"
+
+	startedAt := entity("sensor", "started_at", "Cycle started", "{{ value_json.started_at }}")
+	startedAt.config.DeviceClass = "timestamp"
+
+	estimatedFinish := entity("sensor", "estimated_finish", "Estimated finish", "{{ value_json.estimated_finish_at }}")
+	estimatedFinish.config.DeviceClass = "timestamp"
+
+	lastFinished := entity("sensor", "last_finished", "Last finished", "{{ value_json.last_finished_at }}")
+	lastFinished.config.DeviceClass = "timestamp"
+
+	subscribers := entity("sensor", "subscribers", "Subscribers", "{{ value_json.subscribers }}")
+	subscribers.config.StateClass = "measurement"
+	subscribers.config.Icon = "mdi:account-multiple"
+
+	return []discoveryEntity{running, startedAt, estimatedFinish, lastFinished, subscribers}
+}
@@ -1,177 +1,202 @@
 package mqtt
 
 import (
-	"database/sql"
 	"fmt"
 	laundryNotify "jallier/laundry-notify"
 	"strings"
 	"time"
 
 	"github.com/charmbracelet/log"
-	"golang.org/x/text/cases"
-	"golang.org/x/text/language"
 )
 
 var _ laundryNotify.LaundrySubscriberService = (*LaundrySubscriberService)(nil)
 
 type LaundrySubscriberService struct {
 	mqtt             *MQTTManager
+	appliances       laundryNotify.Appliances
 	eventService     laundryNotify.EventService
 	userEventService laundryNotify.UserEventService
-	ntfyService      laundryNotify.LaundryNotifyService
+	ingestionService laundryNotify.IngestionService
+	eventBus         laundryNotify.EventBus
 }
 
 func NewLaundrySubscriberService(
 	mqtt *MQTTManager,
+	appliances laundryNotify.Appliances,
 	eventService laundryNotify.EventService,
 	userEventService laundryNotify.UserEventService,
-	ntfyService laundryNotify.LaundryNotifyService,
+	ingestionService laundryNotify.IngestionService,
+	eventBus laundryNotify.EventBus,
 ) *LaundrySubscriberService {
 	return &LaundrySubscriberService{
 		mqtt:             mqtt,
+		appliances:       appliances,
 		eventService:     eventService,
 		userEventService: userEventService,
-		ntfyService:      ntfyService,
+		ingestionService: ingestionService,
+		eventBus:         eventBus,
 	}
 }
 
 func (s *LaundrySubscriberService) Subscribe(topic string) {
-	eventsChannel := make(chan [2]string)
-	err := s.mqtt.Subscribe(topic, eventsChannel)
+	err := s.mqtt.Subscribe(topic, s.queueKey, s.handleMessage)
 	if err != nil {
 		log.Error("Error subscribing to MQTT topic", "topic", topic, "error", err)
 		return
 	}
+}
 
-	go func() {
-		for incomingEvent := range eventsChannel {
-			log.Debug("Received event", "topic", incomingEvent[0], "payload", incomingEvent[1])
-
-			topicSlice := strings.Split(incomingEvent[0], "/")
-			leafTopic := topicSlice[len(topicSlice)-1]
-
-			messageSlice := strings.Split(incomingEvent[1], "=")
-			messageKey := messageSlice[0]
-			messageValue := messageSlice[1]
-
-			switch messageKey {
-			case "started_at":
-				s.addNewEvent(leafTopic, messageValue)
-			case "finished_at":
-				s.finishExistingEvent(leafTopic, messageValue)
-			}
+// Reconcile flags events that have been open for longer than their appliance's longest cycle, as their
+// finish was probably missed while the service was down. It should be run on startup
+func (s *LaundrySubscriberService) Reconcile() error {
+	now := time.Now()
+	for _, appliance := range s.appliances {
+		events, err := s.eventService.FlagStaleEvents(s.mqtt.ctx, appliance.Id, now.Add(-appliance.MaxCycle))
+		if err != nil {
+			log.Error("Error flagging stale events", "type", appliance.Id, "error", err)
+			return err
 		}
-	}()
+		for _, event := range events {
+			log.Warn("Event has been open for longer than the appliance's max cycle, its finish was probably missed",
+				"type", appliance.Id, "id", event.Id, "started_at", event.StartedAt.Time, "max_cycle", appliance.MaxCycle)
+		}
+	}
+	return nil
 }
 
-func (s *LaundrySubscriberService) addNewEvent(eventType string, startedAtTimestamp string) error {
-	startedAt, err := time.Parse(time.RFC3339, startedAtTimestamp)
-	if err != nil {
-		log.Error("Error parsing started_at timestamp", "error", err)
-		return err
+// queueKey handles messages for an appliance in order with its power readings, using the leaf topic
+func (s *LaundrySubscriberService) queueKey(topic string) string {
+	topicSlice := strings.Split(topic, "/")
+	if appliance := s.appliances.FindByTopic(topicSlice[len(topicSlice)-1]); appliance != nil {
+		return appliance.Id
 	}
+	return topic
+}
 
-	log.Info("New event received", "type", eventType, "started_at", startedAt)
+// handleMessage records a started_at/finished_at message against the appliance using the leaf topic.
+// Messages that can't be used are skipped, and only a failure to record one is returned
+func (s *LaundrySubscriberService) handleMessage(topic string, payload string) error {
+	log.Debug("Received event", "topic", topic, "payload", payload)
 
-	// Check if we have an existing event of the same type that hasn't been finished - this means we should just skip this event since its likely a doubleup of the same event
-	result, err := s.eventService.FindMostRecentEvent(s.mqtt.ctx, eventType)
-	if err != nil {
-		log.Error("Error finding most recent event", "error", err)
-		return err
+	topicSlice := strings.Split(topic, "/")
+	leafTopic := topicSlice[len(topicSlice)-1]
+	appliance := s.appliances.FindByTopic(leafTopic)
+	if appliance == nil {
+		log.Warn("No appliance configured for topic, skipping", "topic", topic)
+		return nil
 	}
-	log.Debug("Most recent event", "result", result)
-	if result == nil || result.FinishedAt.Valid {
-		log.Debug("No existing unfinished event found, inserting new event")
-		event := &laundryNotify.Event{
-			Type:      eventType,
-			StartedAt: sql.NullTime{Time: startedAt, Valid: true},
-		}
-		err := s.eventService.CreateEvent(s.mqtt.ctx, event)
-		if err != nil {
-			log.Error("Error creating new event", "error", err)
-			return err
-		}
-		log.Info("New event inserted", "type", eventType, "started_at", startedAt, "id", event.Id)
-		log.Debug("Checking for users subscribed to future event")
-		userEvents, n, err := s.userEventService.FindUpcomingUserEvents(s.mqtt.ctx, eventType)
-		if err != nil {
-			log.Error("Error finding upcoming user events", "error", err)
-			return err
-		}
-		log.Debug("Upcoming user events", "count", n, "events", userEvents)
-		if n == 0 {
-			log.Info("No users subscribed to future event")
-			return nil
-		}
-		for _, userEvent := range userEvents {
-			_, err = s.userEventService.UpdateUserEvent(s.mqtt.ctx, userEvent.Id, laundryNotify.UserEventUpdate{EventId: event.Id})
-			if err != nil {
-				log.Error("Error updating user event", "error", err)
-				continue
-			}
-			log.Debug("Updated user events", "event_id", userEvent.Id)
-		}
-	} else {
-		log.Info("existing event found, not adding event")
+
+	message, err := parseMessage(payload)
+	if err != nil {
+		log.Error("Error parsing message", "topic", topic, "payload", payload, "error", err)
 		return nil
 	}
+	if message.PowerW != nil {
+		log.Debug("Message power reading", "topic", topic, "power_w", *message.PowerW)
+	}
 
-	return nil
+	return s.ingest(appliance.Id, message.Kind, message.At, topic, payload)
 }
 
-func (s *LaundrySubscriberService) finishExistingEvent(eventType string, finishedAtTimestamp string) error {
-	finishedAt, err := time.Parse(time.RFC3339, finishedAtTimestamp)
-	if err != nil {
-		log.Error("Error parsing finished_at timestamp", "error", err)
-		return err
+// ingest starts or finishes an event of the type, unless a message with the same kind and timestamp was
+// already accepted for it, eg a retained message seen again on reconnect. The decision is recorded in the
+// ingestion log in the same transaction. An error means the message couldn't be applied or recorded, and
+// can be tried again
+func (s *LaundrySubscriberService) ingest(eventType string, kind string, at time.Time, topic string, payload string) error {
+	ingestion := &laundryNotify.Ingestion{
+		Type:    eventType,
+		Kind:    kind,
+		At:      at,
+		Topic:   topic,
+		Payload: payload,
 	}
 
-	log.Info("New event received", "type", eventType, "finished_at", finishedAt)
+	switch kind {
+	case STARTED_MESSAGE:
+		return s.addNewEvent(ingestion)
+	case FINISHED_MESSAGE:
+		return s.finishExistingEvent(ingestion)
+	}
+	return nil
+}
+
+func (s *LaundrySubscriberService) addNewEvent(ingestion *laundryNotify.Ingestion) error {
+	eventType, startedAt := ingestion.Type, ingestion.At
+	log.Info("New event received", "type", eventType, "started_at", startedAt)
 
-	// Check if we have an existing event of the same type that hasn't been finished - this means we should just skip this event since its likely a doubleup of the same event
-	mostRecentEvent, err := s.eventService.FindMostRecentEvent(s.mqtt.ctx, eventType)
+	// The event is only created if there isn't an unfinished one of the same type, as that means this is
+	// likely a doubleup of the same event. Pending and standing subscriptions are attached in the same transaction
+	event, userEvents, err := s.ingestionService.IngestStart(s.mqtt.ctx, ingestion)
 	if err != nil {
-		log.Error("Error finding most recent event", "error", err)
+		log.Error("Error starting new event", "type", eventType, "started_at", startedAt, "error", err)
 		return err
 	}
-	log.Debug("Most recent event", "result", mostRecentEvent)
-	if mostRecentEvent.FinishedAt.Valid {
-		log.Info("No existing unfinished event found, skipping")
+	switch ingestion.Decision {
+	case laundryNotify.INGEST_ACCEPTED:
+	case laundryNotify.INGEST_DUPLICATE:
+		log.Info("Message already handled, skipping", "type", eventType, "started_at", startedAt)
+		return nil
+	case laundryNotify.INGEST_IGNORED:
+		log.Info("existing event found, not adding event", "type", eventType, "started_at", startedAt)
+		return nil
+	case laundryNotify.INGEST_LATE:
+		log.Warn("Start arrived after its cycle finished, not adding event", "type", eventType, "started_at", startedAt, "reason", ingestion.Reason)
+		return nil
+	default:
+		log.Warn("Start wasn't applied, not adding event", "type", eventType, "started_at", startedAt, "reason", ingestion.Reason)
 		return nil
 	}
-
-	log.Debug("Existing unfinished event found, updating event")
-	_, err = s.eventService.UpdateEvent(s.mqtt.ctx, mostRecentEvent.Id, laundryNotify.EventUpdate{
-		FinishedAt: sql.NullTime{Time: finishedAt, Valid: true},
-	})
-	if err != nil {
-		log.Error("Error updating existing event", "error", err)
-		return err
+	log.Info("New event inserted", "type", eventType, "started_at", startedAt, "id", event.Id, "subscribers", len(userEvents))
+	if len(userEvents) == 0 {
+		log.Info("No users subscribed to future event")
 	}
-	log.Info("Existing event updated", "type", eventType, "finished_at", finishedAt)
+	s.eventBus.Publish(laundryNotify.ApplianceUpdate{Kind: laundryNotify.EVENT_STARTED, Event: event})
+	return nil
+}
+
+func (s *LaundrySubscriberService) finishExistingEvent(ingestion *laundryNotify.Ingestion) error {
+	eventType, finishedAt := ingestion.Type, ingestion.At
+	log.Info("New event received", "type", eventType, "finished_at", finishedAt)
 
-	// Check the user events for any users that are subscribed to this event type
-	usernames, err := s.userEventService.FindUserNamesByEventId(s.mqtt.ctx, mostRecentEvent.Id)
+	// The running event is found, finished, and its notifications queued and reminders scheduled in the same
+	// transaction. The notifications are delivered by the dispatcher
+	finishedEvent, notifications, err := s.ingestionService.IngestFinish(s.mqtt.ctx, ingestion, laundryNotify.Notification{
+		Title:   fmt.Sprintf("%s event finished", s.applianceName(eventType)),
+		Message: "Your laundry is ready!",
+	}, s.reminders(eventType))
 	if err != nil {
-		log.Error("Error finding usernames by event id", "error", err)
+		log.Error("Error finishing existing event", "type", eventType, "finished_at", finishedAt, "error", err)
 		return err
 	}
-
-	for _, username := range usernames {
-		topic := strings.ReplaceAll(username, " ", "_")
-		title := fmt.Sprintf("%s event finished", toTitleCase(eventType))
-		message := "Your laundry is ready!"
-		err := s.ntfyService.Notify(topic, title, message)
-		if err != nil {
-			log.Error("Error notifying user", "error", err)
-			return err
-		}
-		log.Info("User notified", "username", username, "eventId", mostRecentEvent.Id)
+	switch ingestion.Decision {
+	case laundryNotify.INGEST_ACCEPTED:
+	case laundryNotify.INGEST_DUPLICATE:
+		log.Info("Message already handled, skipping", "type", eventType, "finished_at", finishedAt)
+		return nil
+	case laundryNotify.INGEST_IGNORED:
+		log.Info("No existing unfinished event found, skipping")
+		return nil
+	default:
+		log.Warn("Finish wasn't applied, skipping", "type", eventType, "finished_at", finishedAt, "reason", ingestion.Reason)
+		return nil
 	}
+	log.Info("Existing event updated", "type", eventType, "finished_at", finishedAt, "notifications", len(notifications))
+	s.eventBus.Publish(laundryNotify.ApplianceUpdate{Kind: laundryNotify.EVENT_FINISHED, Event: finishedEvent})
+	return nil
+}
 
+// reminders returns how long after a load of the type finishes to remind its subscribers to collect it
+func (s *LaundrySubscriberService) reminders(eventType string) []time.Duration {
+	if appliance := s.appliances.Find(eventType); appliance != nil {
+		return appliance.Reminders
+	}
 	return nil
 }
 
-func toTitleCase(s string) string {
-	return cases.Title(language.English).String(s)
+// applianceName returns the display name for an event type, falling back to the type itself
+func (s *LaundrySubscriberService) applianceName(eventType string) string {
+	if appliance := s.appliances.Find(eventType); appliance != nil {
+		return appliance.Name
+	}
+	return eventType
 }
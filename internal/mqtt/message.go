@@ -0,0 +1,95 @@
+package mqtt
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const STARTED_MESSAGE = "started_at"
+const FINISHED_MESSAGE = "finished_at"
+
+// Message is a parsed laundry event received over mqtt
+type Message struct {
+	Kind   string
+	At     time.Time
+	PowerW *float64
+}
+
+// jsonMessage is the json payload format, eg {"event":"started","at":"2024-01-01T10:00:00Z","power_w":512}
+type jsonMessage struct {
+	Event  string   `json:"event"`
+	At     string   `json:"at"`
+	PowerW *float64 `json:"power_w"`
+}
+
+// parseMessage parses an incoming payload. Json payloads are tried first, falling back to the
+// legacy `started_at=<timestamp>` / `finished_at=<timestamp>` format.
+func parseMessage(payload string) (*Message, error) {
+	payload = strings.TrimSpace(payload)
+	if payload == "" {
+		return nil, fmt.Errorf("empty payload")
+	}
+
+	if strings.HasPrefix(payload, "{") {
+		return parseJsonMessage(payload)
+	}
+
+	return parseLegacyMessage(payload)
+}
+
+func parseJsonMessage(payload string) (*Message, error) {
+	var msg jsonMessage
+	if err := json.Unmarshal([]byte(payload), &msg); err != nil {
+		return nil, fmt.Errorf("invalid json payload: %w", err)
+	}
+
+	kind, err := messageKind(msg.Event)
+	if err != nil {
+		return nil, err
+	}
+
+	if msg.At == "" {
+		return nil, fmt.Errorf("missing event timestamp")
+	}
+	at, err := time.Parse(time.RFC3339, msg.At)
+	if err != nil {
+		return nil, fmt.Errorf("invalid event timestamp: %w", err)
+	}
+
+	return &Message{Kind: kind, At: at, PowerW: msg.PowerW}, nil
+}
+
+func parseLegacyMessage(payload string) (*Message, error) {
+	key, value, found := strings.Cut(payload, "=")
+	if !found {
+		return nil, fmt.Errorf("payload is not in key=value format")
+	}
+
+	kind, err := messageKind(strings.TrimSpace(key))
+	if err != nil {
+		return nil, err
+	}
+
+	at, err := time.Parse(time.RFC3339, strings.TrimSpace(value))
+	if err != nil {
+		return nil, fmt.Errorf("invalid event timestamp: %w", err)
+	}
+
+	return &Message{Kind: kind, At: at}, nil
+}
+
+// messageKind normalises the event name, accepting both `started` and `started_at` style names
+func messageKind(event string) (string, error) {
+	switch strings.ToLower(event) {
+	case "started", STARTED_MESSAGE:
+		return STARTED_MESSAGE, nil
+	case "finished", FINISHED_MESSAGE:
+		return FINISHED_MESSAGE, nil
+	case "":
+		return "", fmt.Errorf("missing event type")
+	default:
+		return "", fmt.Errorf("unknown event type: %s", event)
+	}
+}
@@ -2,19 +2,49 @@ package mqtt
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
+	laundryNotify "jallier/laundry-notify"
+	"os"
+	"sync"
+	"time"
 
 	"github.com/charmbracelet/log"
 	MQTT "github.com/eclipse/paho.mqtt.golang"
 )
 
+const AVAILABILITY_ONLINE = "online"
+const AVAILABILITY_OFFLINE = "offline"
+
 type Client = MQTT.Client
 
+// Ensure type implements interface.
+var _ laundryNotify.MQTTStatusService = (*MQTTManager)(nil)
+
 type MQTTManager struct {
-	MqttOpts   *MQTT.ClientOptions
+	MqttOpts *MQTT.ClientOptions
+	// Topic that online/offline is published to, retained. The broker publishes offline as our last will
+	// if the connection drops. Disabled if empty
+	AvailabilityTopic string
+	// QoS used for subscriptions. Defaults to 0
+	QoS byte
+	// Messages are handled from this queue, rather than in the mqtt client's callback
+	Queue      *Queue
 	mqttClient *MQTT.Client
 	ctx        context.Context
 	cancel     func()
+
+	mu            sync.Mutex
+	status        laundryNotify.MQTTStatus
+	hasConnected  bool
+	subscriptions []subscription
+}
+
+type subscription struct {
+	topic   string
+	key     QueueKey
+	handler MessageHandler
 }
 
 func NewMqttOpts() *MQTT.ClientOptions {
@@ -26,6 +56,7 @@ func NewMQTTManager() *MQTTManager {
 	manager := &MQTTManager{
 		MqttOpts:   nil,
 		mqttClient: nil,
+		Queue:      NewQueue(DefaultQueueSize, QUEUE_BLOCK),
 	}
 	manager.ctx, manager.cancel = context.WithCancel(context.Background())
 	return manager
@@ -39,10 +70,40 @@ func (m *MQTTManager) Connect() (MQTT.Token, error) {
 		return nil, fmt.Errorf("already connected to MQTT broker")
 	}
 
+	if m.AvailabilityTopic != "" {
+		m.MqttOpts.SetWill(m.AvailabilityTopic, AVAILABILITY_OFFLINE, byte(1), true)
+	}
+
+	// Track the connection state, and announce we're online and renew subscriptions before anything else
+	// runs on connect
+	onConnect := m.MqttOpts.OnConnect
+	m.MqttOpts.SetOnConnectHandler(func(client MQTT.Client) {
+		m.connected()
+		if m.AvailabilityTopic != "" {
+			token := client.Publish(m.AvailabilityTopic, byte(1), true, AVAILABILITY_ONLINE)
+			token.Wait()
+			if err := token.Error(); err != nil {
+				log.Error("Error publishing mqtt availability", "topic", m.AvailabilityTopic, "error", err)
+			}
+		}
+		for _, sub := range m.subscriptionList() {
+			if err := m.subscribe(client, sub); err != nil {
+				log.Error("Error subscribing to MQTT topic", "topic", sub.topic, "error", err)
+			}
+		}
+		if onConnect != nil {
+			onConnect(client)
+		}
+	})
+
+	// Messages are acknowledged once they've been handled, rather than when they're queued
+	m.MqttOpts.SetAutoAckDisabled(true)
+
 	// Log events
 	m.MqttOpts.SetAutoReconnect(true)
 	m.MqttOpts.OnConnectionLost = func(cl MQTT.Client, err error) {
-		log.Info("mqtt connection lost")
+		log.Info("mqtt connection lost", "error", err)
+		m.disconnected()
 	}
 	m.MqttOpts.OnReconnecting = func(MQTT.Client, *MQTT.ClientOptions) {
 		log.Info("mqtt attempting to reconnect")
@@ -50,6 +111,13 @@ func (m *MQTTManager) Connect() (MQTT.Token, error) {
 
 	newClient := MQTT.NewClient(m.MqttOpts)
 	m.mqttClient = &newClient
+
+	// Route messages for existing subscriptions as soon as we connect, as a persistent session can send
+	// messages it kept for us before we get a chance to subscribe again
+	for _, sub := range m.subscriptionList() {
+		newClient.AddRoute(sub.topic, m.messageHandler(sub))
+	}
+
 	token := (*m.mqttClient).Connect()
 	token.Wait()
 	if token.Error() != nil {
@@ -60,15 +128,41 @@ func (m *MQTTManager) Connect() (MQTT.Token, error) {
 	return token, nil
 }
 
+// Disconnect marks the service offline, as the broker only publishes the last will if the connection
+// drops, then closes the connection and waits for any queued messages to be handled
 func (m *MQTTManager) Disconnect() {
+	if m.mqttClient == nil {
+		return
+	}
+	if m.AvailabilityTopic != "" && (*m.mqttClient).IsConnected() {
+		token := (*m.mqttClient).Publish(m.AvailabilityTopic, byte(1), true, AVAILABILITY_OFFLINE)
+		if !token.WaitTimeout(time.Second) || token.Error() != nil {
+			log.Warn("Error publishing mqtt availability", "topic", m.AvailabilityTopic, "error", token.Error())
+		}
+	}
 	(*m.mqttClient).Disconnect(250)
+	m.disconnected()
+	m.Queue.Close()
 }
 
-func (m *MQTTManager) Subscribe(topic string, eventChannel chan<- [2]string) error {
-	log.Debug("Subscribing to MQTT topic...", "topic", topic)
-	token := (*m.mqttClient).Subscribe(topic, byte(0), func(client MQTT.Client, msg MQTT.Message) {
-		eventChannel <- [2]string{msg.Topic(), string(msg.Payload())}
-	})
+// Subscribe calls the handler for each message received on the topic. Messages are queued, and handled
+// in order for each key, so messages for an appliance on different topics stay in order. Subscriptions are
+// renewed whenever the connection is established, so they can be made before connecting
+func (m *MQTTManager) Subscribe(topic string, key QueueKey, handler MessageHandler) error {
+	sub := subscription{topic: topic, key: key, handler: handler}
+	m.mu.Lock()
+	m.subscriptions = append(m.subscriptions, sub)
+	m.mu.Unlock()
+
+	if m.mqttClient == nil {
+		return nil
+	}
+	return m.subscribe(*m.mqttClient, sub)
+}
+
+func (m *MQTTManager) subscribe(client MQTT.Client, sub subscription) error {
+	log.Debug("Subscribing to MQTT topic...", "topic", sub.topic, "qos", m.QoS)
+	token := client.Subscribe(sub.topic, m.QoS, m.messageHandler(sub))
 	token.Wait()
 	if err := token.Error(); err != nil {
 		log.Error("Error subscribing to MQTT topic: %v", token.Error())
@@ -76,3 +170,111 @@ func (m *MQTTManager) Subscribe(topic string, eventChannel chan<- [2]string) err
 	}
 	return nil
 }
+
+// messageHandler queues messages for the subscription. Automatic acknowledgement is turned off, so a
+// message is only acknowledged once it has been handled, and the broker delivers it again if we crash first
+func (m *MQTTManager) messageHandler(sub subscription) MQTT.MessageHandler {
+	return func(client MQTT.Client, msg MQTT.Message) {
+		m.received()
+		m.Queue.Push(sub.key(msg.Topic()), msg.Topic(), string(msg.Payload()), sub.handler, msg.Ack)
+	}
+}
+
+func (m *MQTTManager) subscriptionList() []subscription {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]subscription(nil), m.subscriptions...)
+}
+
+// Publish sends the payload to the topic. Retained messages are kept by the broker and sent to new subscribers
+func (m *MQTTManager) Publish(topic string, payload []byte, retained bool) error {
+	if m.mqttClient == nil {
+		return fmt.Errorf("not connected to MQTT broker")
+	}
+	token := (*m.mqttClient).Publish(topic, byte(0), retained, payload)
+	token.Wait()
+	if err := token.Error(); err != nil {
+		log.Error("Error publishing to MQTT topic", "topic", topic, "error", err)
+		return err
+	}
+	return nil
+}
+
+// MQTTStatus returns the current state of the connection to the broker
+func (m *MQTTManager) MQTTStatus() laundryNotify.MQTTStatus {
+	m.mu.Lock()
+	status := m.status
+	m.mu.Unlock()
+
+	status.Queued, status.Blocked, status.Dropped = m.Queue.Stats()
+	return status
+}
+
+func (m *MQTTManager) connected() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.hasConnected {
+		m.status.Reconnects++
+	}
+	m.hasConnected = true
+	m.status.Connected = true
+	m.status.ConnectedAt = time.Now()
+}
+
+func (m *MQTTManager) disconnected() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.status.Connected = false
+	m.status.ConnectedAt = time.Time{}
+}
+
+func (m *MQTTManager) received() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.status.LastMessageAt = time.Now()
+}
+
+// NewFileStore returns a store that keeps in flight messages in the directory, so they survive a restart
+func NewFileStore(dir string) MQTT.Store {
+	return MQTT.NewFileStore(dir)
+}
+
+// NewTLSConfig returns the tls config for connecting to the broker. The CA bundle is added to the system
+// roots if set, and the client certificate and key are used for mutual TLS if set
+func NewTLSConfig(caFile string, certFile string, keyFile string, insecureSkipVerify bool) (*tls.Config, error) {
+	config := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: insecureSkipVerify,
+	}
+
+	if caFile != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read ca bundle: %w", err)
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in ca bundle: %s", caFile)
+		}
+		config.RootCAs = pool
+	}
+
+	if certFile != "" || keyFile != "" {
+		if certFile == "" || keyFile == "" {
+			return nil, fmt.Errorf("client certificate and key must both be set")
+		}
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	return config, nil
+}
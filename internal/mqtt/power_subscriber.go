@@ -0,0 +1,192 @@
+package mqtt
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/log"
+)
+
+// PowerThresholds configures when a power reading counts as an appliance running or stopped
+type PowerThresholds struct {
+	// Readings at or above this wattage count towards a cycle starting
+	Start float64
+	// Readings at or below this wattage count towards a cycle finishing
+	Stop float64
+	// How long readings must stay past a threshold before the state changes
+	Debounce time.Duration
+}
+
+// PowerSubscriberService detects cycles from raw smart plug power readings, rather than relying on
+// something upstream to publish started_at/finished_at messages
+type PowerSubscriberService struct {
+	mqtt       *MQTTManager
+	laundry    *LaundrySubscriberService
+	Thresholds PowerThresholds
+
+	mu       sync.Mutex
+	monitors map[string]*powerMonitor
+
+	// Now returns the time a reading was received, used when the reading doesn't have its own timestamp.
+	// Defaults to time.Now
+	Now func() time.Time
+}
+
+func NewPowerSubscriberService(mqtt *MQTTManager, laundry *LaundrySubscriberService, thresholds PowerThresholds) *PowerSubscriberService {
+	return &PowerSubscriberService{
+		mqtt:       mqtt,
+		laundry:    laundry,
+		Thresholds: thresholds,
+		monitors:   make(map[string]*powerMonitor),
+		Now:        time.Now,
+	}
+}
+
+// Subscribe listens for power readings on the topic, and records cycles against the given event type
+func (s *PowerSubscriberService) Subscribe(topic string, eventType string) {
+	// Readings are handled in order with the appliance's start and finish messages
+	key := func(string) string { return eventType }
+	err := s.mqtt.Subscribe(topic, key, func(topic string, payload string) error {
+		power, at, err := parsePowerReading(payload)
+		if err != nil {
+			log.Error("Error parsing power reading", "topic", topic, "payload", payload, "error", err)
+			return nil
+		}
+		if at.IsZero() {
+			at = s.Now()
+		}
+		log.Debug("Received power reading", "type", eventType, "power_w", power, "at", at)
+
+		monitor := s.monitor(eventType)
+		previous := *monitor
+		kind, since := monitor.update(power, at, s.Thresholds)
+		if kind == "" {
+			return nil
+		}
+		if err := s.laundry.ingest(eventType, kind, since, topic, payload); err != nil {
+			// Go back to the state before the reading, so the change is seen again when it's redelivered
+			*monitor = previous
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		log.Error("Error subscribing to MQTT power topic", "topic", topic, "error", err)
+		return
+	}
+}
+
+func (s *PowerSubscriberService) monitor(eventType string) *powerMonitor {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	m, ok := s.monitors[eventType]
+	if !ok {
+		m = &powerMonitor{}
+		s.monitors[eventType] = m
+	}
+	return m
+}
+
+type powerState int
+
+const (
+	powerIdle powerState = iota
+	powerRunning
+)
+
+// powerMonitor is the state machine for a single appliance
+type powerMonitor struct {
+	state powerState
+	// When readings first crossed the threshold for the next state. Zero if they haven't
+	pendingSince time.Time
+}
+
+// update feeds a reading into the state machine. If the appliance changed state, the message kind and
+// the time the change began are returned, otherwise kind is empty.
+func (m *powerMonitor) update(power float64, at time.Time, thresholds PowerThresholds) (kind string, since time.Time) {
+	var crossed bool
+	switch m.state {
+	case powerIdle:
+		crossed = power >= thresholds.Start
+	case powerRunning:
+		crossed = power <= thresholds.Stop
+	}
+
+	if !crossed {
+		m.pendingSince = time.Time{}
+		return "", time.Time{}
+	}
+	if m.pendingSince.IsZero() {
+		m.pendingSince = at
+	}
+	if at.Sub(m.pendingSince) < thresholds.Debounce {
+		return "", time.Time{}
+	}
+
+	since = m.pendingSince
+	m.pendingSince = time.Time{}
+	if m.state == powerIdle {
+		m.state = powerRunning
+		return STARTED_MESSAGE, since
+	}
+	m.state = powerIdle
+	return FINISHED_MESSAGE, since
+}
+
+// Layout of tasmota's `Time` field, which has no zone so is read as the server's local time
+const tasmotaTimeLayout = "2006-01-02T15:04:05"
+
+// parsePowerReading extracts the wattage from a plug payload, and the time it was taken if the payload has
+// one, otherwise at is zero. Supports a plain number (eg shelly gen1 `relay/0/power`), tasmota
+// `{"Time":"2024-01-01T10:00:00","ENERGY":{"Power":512}}`, shelly gen2 `{"apower":512}` and
+// `{"power_w":512}`. Json payloads can also have an RFC3339 `at` timestamp
+func parsePowerReading(payload string) (power float64, at time.Time, err error) {
+	payload = strings.TrimSpace(payload)
+	if payload == "" {
+		return 0, time.Time{}, fmt.Errorf("empty payload")
+	}
+
+	if !strings.HasPrefix(payload, "{") {
+		power, err = strconv.ParseFloat(payload, 64)
+		return power, time.Time{}, err
+	}
+
+	var reading struct {
+		At     string `json:"at"`
+		Time   string `json:"Time"`
+		Energy *struct {
+			Power *float64 `json:"Power"`
+		} `json:"ENERGY"`
+		APower *float64 `json:"apower"`
+		PowerW *float64 `json:"power_w"`
+	}
+	if err := json.Unmarshal([]byte(payload), &reading); err != nil {
+		return 0, time.Time{}, fmt.Errorf("invalid json payload: %w", err)
+	}
+
+	switch {
+	case reading.At != "":
+		if at, err = time.Parse(time.RFC3339, reading.At); err != nil {
+			return 0, time.Time{}, fmt.Errorf("invalid reading timestamp: %w", err)
+		}
+	case reading.Time != "":
+		if at, err = time.ParseInLocation(tasmotaTimeLayout, reading.Time, time.Local); err != nil {
+			return 0, time.Time{}, fmt.Errorf("invalid reading timestamp: %w", err)
+		}
+	}
+
+	switch {
+	case reading.Energy != nil && reading.Energy.Power != nil:
+		return *reading.Energy.Power, at, nil
+	case reading.APower != nil:
+		return *reading.APower, at, nil
+	case reading.PowerW != nil:
+		return *reading.PowerW, at, nil
+	}
+	return 0, time.Time{}, fmt.Errorf("no power reading found in payload")
+}
@@ -0,0 +1,128 @@
+package mqtt
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPowerMonitor_Update(t *testing.T) {
+	thresholds := PowerThresholds{Start: 10, Stop: 5, Debounce: 2 * time.Minute}
+	start := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+
+	type reading struct {
+		power float64
+		after time.Duration
+		// Kind and time of the change expected after the reading, if any
+		kind  string
+		since time.Duration
+	}
+	tests := []struct {
+		name     string
+		readings []reading
+	}{
+		{
+			name: "below start threshold",
+			readings: []reading{
+				{power: 0, after: 0},
+				{power: 9, after: time.Minute},
+				{power: 9.9, after: 10 * time.Minute},
+			},
+		},
+		{
+			name: "starts once debounce expires",
+			readings: []reading{
+				{power: 10, after: 0},
+				{power: 500, after: time.Minute},
+				{power: 500, after: 2 * time.Minute, kind: STARTED_MESSAGE, since: 0},
+			},
+		},
+		{
+			name: "spike doesn't start",
+			readings: []reading{
+				{power: 500, after: 0},
+				{power: 0, after: time.Minute},
+				{power: 500, after: 2 * time.Minute},
+				{power: 500, after: 3 * time.Minute},
+				{power: 500, after: 4 * time.Minute, kind: STARTED_MESSAGE, since: 2 * time.Minute},
+			},
+		},
+		{
+			name: "finishes once debounce expires",
+			readings: []reading{
+				{power: 500, after: 0},
+				{power: 500, after: 2 * time.Minute, kind: STARTED_MESSAGE, since: 0},
+				{power: 5, after: 30 * time.Minute},
+				{power: 0, after: 31 * time.Minute},
+				{power: 0, after: 32 * time.Minute, kind: FINISHED_MESSAGE, since: 30 * time.Minute},
+			},
+		},
+		{
+			name: "dip while running doesn't finish",
+			readings: []reading{
+				{power: 500, after: 0},
+				{power: 500, after: 2 * time.Minute, kind: STARTED_MESSAGE, since: 0},
+				{power: 0, after: 10 * time.Minute},
+				{power: 7, after: 11 * time.Minute},
+				{power: 0, after: 13 * time.Minute},
+				{power: 0, after: 14 * time.Minute},
+				{power: 0, after: 15 * time.Minute, kind: FINISHED_MESSAGE, since: 13 * time.Minute},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			monitor := &powerMonitor{}
+			for i, r := range tt.readings {
+				kind, since := monitor.update(r.power, start.Add(r.after), thresholds)
+				if kind != r.kind {
+					t.Fatalf("reading %d: kind = %q, want %q", i, kind, r.kind)
+				}
+				if kind != "" && !since.Equal(start.Add(r.since)) {
+					t.Fatalf("reading %d: since = %s, want %s", i, since, start.Add(r.since))
+				}
+			}
+		})
+	}
+}
+
+func TestParsePowerReading(t *testing.T) {
+	at := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		payload string
+		power   float64
+		at      time.Time
+		wantErr bool
+	}{
+		{name: "plain number", payload: " 512.5\n", power: 512.5},
+		{name: "tasmota", payload: `{"Time":"2024-01-01T10:00:00","ENERGY":{"Power":512}}`, power: 512, at: time.Date(2024, 1, 1, 10, 0, 0, 0, time.Local)},
+		{name: "shelly gen2", payload: `{"id":0,"apower":512}`, power: 512},
+		{name: "power_w with at", payload: `{"at":"2024-01-01T10:00:00Z","power_w":512}`, power: 512, at: at},
+		{name: "zero power", payload: `{"power_w":0}`, power: 0},
+		{name: "empty", payload: " ", wantErr: true},
+		{name: "not a number", payload: "on", wantErr: true},
+		{name: "invalid json", payload: `{"power_w":`, wantErr: true},
+		{name: "no power", payload: `{"ENERGY":{"Voltage":230}}`, wantErr: true},
+		{name: "invalid at", payload: `{"at":"yesterday","power_w":512}`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			power, readAt, err := parsePowerReading(tt.payload)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got power=%v", power)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if power != tt.power {
+				t.Errorf("power = %v, want %v", power, tt.power)
+			}
+			if !readAt.Equal(tt.at) {
+				t.Errorf("at = %s, want %s", readAt, tt.at)
+			}
+		})
+	}
+}
@@ -0,0 +1,162 @@
+package mqtt
+
+import (
+	"sync"
+
+	"github.com/charmbracelet/log"
+)
+
+const DefaultQueueSize = 100
+
+// When a worker's queue is full, QUEUE_BLOCK waits for space, holding up the mqtt client until there is,
+// while QUEUE_DROP discards the message
+const QUEUE_BLOCK = "block"
+const QUEUE_DROP = "drop"
+
+// MessageHandler processes a message received on a subscribed topic. Returning an error means the message
+// couldn't be handled and should be delivered again, so it isn't acknowledged
+type MessageHandler func(topic string, payload string) error
+
+// QueueKey returns the key of the worker that handles messages received on the topic. Messages with the
+// same key are handled in order
+type QueueKey func(topic string) string
+
+type queuedMessage struct {
+	topic   string
+	payload string
+	handler MessageHandler
+	// Acknowledges the message to the broker once it has been handled
+	ack func()
+}
+
+// Queue hands messages off from the mqtt client to a worker for each key, usually an appliance, so messages
+// for an appliance are processed in order, but a slow one doesn't hold up the client or the other appliances
+type Queue struct {
+	// Number of messages buffered for each key
+	Size int
+	// Either QUEUE_BLOCK or QUEUE_DROP
+	Policy string
+
+	mu      sync.Mutex
+	workers map[string]chan queuedMessage
+	closed  bool
+	// Pushes in progress, which must finish before the workers' channels are closed
+	pushing sync.WaitGroup
+	wg      sync.WaitGroup
+
+	statsMu sync.Mutex
+	blocked int
+	dropped int
+}
+
+func NewQueue(size int, policy string) *Queue {
+	return &Queue{
+		Size:    size,
+		Policy:  policy,
+		workers: make(map[string]chan queuedMessage),
+	}
+}
+
+// Push queues the message for the key's worker, which acknowledges it once the handler succeeds. Messages
+// pushed after the queue is closed are discarded without being acknowledged, so the broker delivers them
+// again. Messages dropped because the queue is full are acknowledged, as they're discarded on purpose
+func (q *Queue) Push(key string, topic string, payload string, handler MessageHandler, ack func()) {
+	worker, ok := q.worker(key)
+	if !ok {
+		return
+	}
+	defer q.pushing.Done()
+
+	message := queuedMessage{topic: topic, payload: payload, handler: handler, ack: ack}
+	select {
+	case worker <- message:
+		return
+	default:
+	}
+
+	if q.Policy == QUEUE_DROP {
+		q.statsMu.Lock()
+		q.dropped++
+		q.statsMu.Unlock()
+		log.Warn("mqtt queue is full, dropping message", "key", key, "topic", topic, "payload", payload)
+		if ack != nil {
+			ack()
+		}
+		return
+	}
+
+	q.statsMu.Lock()
+	q.blocked++
+	q.statsMu.Unlock()
+	log.Warn("mqtt queue is full, waiting for space", "key", key, "topic", topic)
+	worker <- message
+}
+
+// worker returns the channel for the key, starting a worker for it if there isn't one yet, and registers a
+// push in progress. Returns false if the queue is closed
+func (q *Queue) worker(key string) (chan queuedMessage, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return nil, false
+	}
+	q.pushing.Add(1)
+
+	if worker, ok := q.workers[key]; ok {
+		return worker, true
+	}
+
+	worker := make(chan queuedMessage, q.Size)
+	q.workers[key] = worker
+	q.wg.Add(1)
+	go func() {
+		defer q.wg.Done()
+		for message := range worker {
+			if err := message.handler(message.topic, message.payload); err != nil {
+				log.Error("Error handling mqtt message, it will be delivered again", "topic", message.topic, "error", err)
+				continue
+			}
+			if message.ack != nil {
+				message.ack()
+			}
+		}
+	}()
+	return worker, true
+}
+
+// Close stops accepting messages, and waits for the ones already queued to be processed
+func (q *Queue) Close() {
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		q.wg.Wait()
+		return
+	}
+	q.closed = true
+	q.mu.Unlock()
+
+	// Workers keep running until the channels are closed, so blocked pushes can finish
+	q.pushing.Wait()
+	q.mu.Lock()
+	for _, worker := range q.workers {
+		close(worker)
+	}
+	q.mu.Unlock()
+
+	q.wg.Wait()
+}
+
+// Stats returns the number of messages waiting across all workers, how many times a full queue held up
+// the mqtt client, and how many messages were dropped
+func (q *Queue) Stats() (queued int, blocked int, dropped int) {
+	q.mu.Lock()
+	for _, worker := range q.workers {
+		queued += len(worker)
+	}
+	q.mu.Unlock()
+
+	q.statsMu.Lock()
+	defer q.statsMu.Unlock()
+	return queued, q.blocked, q.dropped
+}
@@ -0,0 +1,44 @@
+package notify
+
+import (
+	"context"
+	laundryNotify "jallier/laundry-notify"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Ensure type implements interface.
+var _ laundryNotify.LaundryNotifyService = (*AppriseNotifier)(nil)
+
+// AppriseNotifier sends notifications through an apprise-api compatible server, using a stored
+// configuration key. The topic is sent as the apprise tag, so each user can be routed to their own
+// services. Apprise has no equivalent of NotifyOptions, so a user's priority and language are ignored.
+type AppriseNotifier struct {
+	Server     string
+	Key        string
+	HttpClient *http.Client
+}
+
+func NewAppriseNotifier(server string, key string, client *http.Client) *AppriseNotifier {
+	if client == nil {
+		client = newHttpClient()
+	}
+	return &AppriseNotifier{Server: server, Key: key, HttpClient: client}
+}
+
+type apprisePayload struct {
+	Title string `json:"title"`
+	Body  string `json:"body"`
+	Tag   string `json:"tag,omitempty"`
+}
+
+func (n *AppriseNotifier) Notify(ctx context.Context, topic string, title string, message string) error {
+	return postJson(
+		ctx,
+		n.HttpClient,
+		strings.TrimSuffix(n.Server, "/")+"/notify/"+url.PathEscape(n.Key),
+		nil,
+		apprisePayload{Title: title, Body: message, Tag: topic},
+	)
+}
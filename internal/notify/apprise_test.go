@@ -0,0 +1,32 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAppriseNotifier_Notify(t *testing.T) {
+	var got apprisePayload
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/notify/laundry key" {
+			t.Errorf("path = %s, want /notify/laundry key", r.URL.Path)
+		}
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("decode body: %v", err)
+		}
+	}))
+	defer server.Close()
+
+	n := NewAppriseNotifier(server.URL, "laundry key", nil)
+	if err := n.Notify(context.Background(), "alice", "title", "message"); err != nil {
+		t.Fatal(err)
+	}
+
+	want := apprisePayload{Title: "title", Body: "message", Tag: "alice"}
+	if got != want {
+		t.Errorf("payload = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,212 @@
+package notify
+
+import (
+	"context"
+	"database/sql"
+	laundryNotify "jallier/laundry-notify"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/log"
+)
+
+const DefaultDispatchInterval = 5 * time.Second
+const DefaultBaseBackoff = 30 * time.Second
+const DefaultMaxBackoff = time.Hour
+const DefaultMaxAttempts = 10
+const DefaultDispatchBatchSize = 50
+
+// Dispatcher delivers notifications from the outbox in the background. Failed deliveries are retried
+// with exponential backoff until MaxAttempts is reached, at which point they are marked as failed.
+// Notifications for a user are routed using their preferences, and held during their quiet hours.
+type Dispatcher struct {
+	notificationService laundryNotify.NotificationService
+	userService         laundryNotify.UserService
+	preferencesService  laundryNotify.PreferencesService
+	notifiers           *Registry
+
+	Interval    time.Duration
+	BaseBackoff time.Duration
+	MaxBackoff  time.Duration
+	MaxAttempts int
+	BatchSize   int
+
+	// Now returns the current time. Defaults to time.Now
+	Now func() time.Time
+
+	ctx    context.Context
+	cancel func()
+	done   chan struct{}
+}
+
+func NewDispatcher(
+	notificationService laundryNotify.NotificationService,
+	userService laundryNotify.UserService,
+	preferencesService laundryNotify.PreferencesService,
+	notifiers *Registry,
+) *Dispatcher {
+	d := &Dispatcher{
+		notificationService: notificationService,
+		userService:         userService,
+		preferencesService:  preferencesService,
+		notifiers:           notifiers,
+		Interval:            DefaultDispatchInterval,
+		BaseBackoff:         DefaultBaseBackoff,
+		MaxBackoff:          DefaultMaxBackoff,
+		MaxAttempts:         DefaultMaxAttempts,
+		BatchSize:           DefaultDispatchBatchSize,
+		Now:                 time.Now,
+		done:                make(chan struct{}),
+	}
+	d.ctx, d.cancel = context.WithCancel(context.Background())
+	return d
+}
+
+// Open starts delivering notifications in a background goroutine
+func (d *Dispatcher) Open() {
+	go func() {
+		defer close(d.done)
+
+		ticker := time.NewTicker(d.Interval)
+		defer ticker.Stop()
+
+		for {
+			d.dispatch()
+			select {
+			case <-ticker.C:
+			case <-d.ctx.Done():
+				return
+			}
+		}
+	}()
+	log.Debug("notification dispatcher started", "interval", d.Interval)
+}
+
+// Close stops the dispatcher, waiting for any in flight delivery to finish
+func (d *Dispatcher) Close() error {
+	d.cancel()
+	<-d.done
+	return nil
+}
+
+// dispatch attempts delivery of every notification that is currently due
+func (d *Dispatcher) dispatch() {
+	status := laundryNotify.NOTIFICATION_PENDING
+	notifications, _, err := d.notificationService.FindNotifications(d.ctx, laundryNotify.NotificationFilter{
+		Status: &status,
+		DueBy:  d.Now(),
+		Limit:  d.BatchSize,
+	})
+	if err != nil {
+		log.Error("Error finding pending notifications", "error", err)
+		return
+	}
+
+	for _, notification := range notifications {
+		if d.ctx.Err() != nil {
+			return
+		}
+		d.deliver(notification)
+	}
+}
+
+func (d *Dispatcher) deliver(notification *laundryNotify.Notification) {
+	preferences, err := d.preferences(notification)
+	if err == nil {
+		if until, ok := preferences.QuietUntil(d.Now()); ok {
+			d.hold(notification, until)
+			return
+		}
+		err = d.send(notification, preferences)
+	}
+
+	attempts := notification.Attempts + 1
+	now := d.Now()
+
+	update := laundryNotify.NotificationUpdate{Attempts: &attempts}
+	if err == nil {
+		status := laundryNotify.NOTIFICATION_DELIVERED
+		update.Status = &status
+		update.DeliveredAt = sql.NullTime{Time: now, Valid: true}
+		log.Info("Notification delivered", "id", notification.Id, "user_id", notification.UserId, "event_id", notification.EventId)
+	} else {
+		lastError := err.Error()
+		update.LastError = &lastError
+		if attempts >= d.MaxAttempts {
+			status := laundryNotify.NOTIFICATION_FAILED
+			update.Status = &status
+			log.Error("Notification failed, giving up", "id", notification.Id, "attempts", attempts, "error", err)
+		} else {
+			next := now.Add(d.backoff(attempts))
+			update.NextAttemptAt = sql.NullTime{Time: next, Valid: true}
+			log.Warn("Notification failed, will retry", "id", notification.Id, "attempts", attempts, "next_attempt_at", next, "error", err)
+		}
+	}
+
+	if _, err := d.notificationService.UpdateNotification(d.ctx, notification.Id, update); err != nil {
+		log.Error("Error updating notification", "id", notification.Id, "error", err)
+	}
+}
+
+// hold delays the notification until the end of the user's quiet hours, without counting an attempt
+func (d *Dispatcher) hold(notification *laundryNotify.Notification, until time.Time) {
+	_, err := d.notificationService.UpdateNotification(d.ctx, notification.Id, laundryNotify.NotificationUpdate{
+		NextAttemptAt: sql.NullTime{Time: until, Valid: true},
+	})
+	if err != nil {
+		log.Error("Error updating notification", "id", notification.Id, "error", err)
+		return
+	}
+	log.Debug("Notification held for quiet hours", "id", notification.Id, "user_id", notification.UserId, "until", until)
+}
+
+// preferences returns how the notification should be sent. Notifications with an explicit topic use
+// the defaults
+func (d *Dispatcher) preferences(notification *laundryNotify.Notification) (*laundryNotify.Preferences, error) {
+	if notification.Topic != "" {
+		return &laundryNotify.Preferences{}, nil
+	}
+	return d.preferencesService.FindPreferences(d.ctx, notification.UserId)
+}
+
+func (d *Dispatcher) send(notification *laundryNotify.Notification, preferences *laundryNotify.Preferences) error {
+	topic, err := d.topic(notification, preferences)
+	if err != nil {
+		return err
+	}
+	return d.notifiers.Send(d.ctx, preferences.Channel, topic, notification.Title, notification.Message, laundryNotify.NotifyOptions{
+		Priority: preferences.Priority,
+		Language: preferences.Language,
+	})
+}
+
+// topic returns where the notification should be sent. Notifications for a user are sent to the target
+// in their preferences, or a topic derived from their name
+func (d *Dispatcher) topic(notification *laundryNotify.Notification, preferences *laundryNotify.Preferences) (string, error) {
+	if notification.Topic != "" {
+		return notification.Topic, nil
+	}
+	if preferences.Target != "" {
+		return preferences.Target, nil
+	}
+
+	user, err := d.userService.FindUserById(d.ctx, notification.UserId)
+	if err != nil {
+		return "", err
+	} else if user == nil {
+		return "", laundryNotify.Errorf(laundryNotify.ENOTFOUND, "User not found: %d", notification.UserId)
+	}
+	return strings.ReplaceAll(user.Name, " ", "_"), nil
+}
+
+// backoff returns how long to wait before the next attempt, doubling each time up to MaxBackoff
+func (d *Dispatcher) backoff(attempts int) time.Duration {
+	backoff := d.BaseBackoff
+	for i := 1; i < attempts && backoff < d.MaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > d.MaxBackoff {
+		backoff = d.MaxBackoff
+	}
+	return backoff
+}
@@ -0,0 +1,65 @@
+package notify
+
+import (
+	"context"
+	laundryNotify "jallier/laundry-notify"
+	"net/http"
+	"strings"
+)
+
+// Ensure type implements interface.
+var _ laundryNotify.LaundryNotifyService = (*GotifyNotifier)(nil)
+var _ laundryNotify.OptionsNotifyService = (*GotifyNotifier)(nil)
+
+// GotifyNotifier sends notifications to a gotify server, using an application token
+type GotifyNotifier struct {
+	Server     string
+	Token      string
+	Priority   int
+	HttpClient *http.Client
+}
+
+func NewGotifyNotifier(server string, token string, client *http.Client) *GotifyNotifier {
+	if client == nil {
+		client = newHttpClient()
+	}
+	return &GotifyNotifier{Server: server, Token: token, Priority: 5, HttpClient: client}
+}
+
+type gotifyPayload struct {
+	Title    string                 `json:"title"`
+	Message  string                 `json:"message"`
+	Priority int                    `json:"priority"`
+	Extras   map[string]interface{} `json:"extras,omitempty"`
+}
+
+// Notify sends the message to the gotify application. Gotify has no per-user topics, so the topic is
+// only included in the message extras.
+func (n *GotifyNotifier) Notify(ctx context.Context, topic string, title string, message string) error {
+	return n.NotifyWithOptions(ctx, topic, title, message, laundryNotify.NotifyOptions{})
+}
+
+func (n *GotifyNotifier) NotifyWithOptions(ctx context.Context, topic string, title string, message string, options laundryNotify.NotifyOptions) error {
+	priority := n.Priority
+	switch options.Priority {
+	case laundryNotify.PRIORITY_LOW:
+		priority = 2
+	case laundryNotify.PRIORITY_HIGH:
+		priority = 8
+	}
+
+	return postJson(
+		ctx,
+		n.HttpClient,
+		strings.TrimSuffix(n.Server, "/")+"/message",
+		map[string]string{"X-Gotify-Key": n.Token},
+		gotifyPayload{
+			Title:    title,
+			Message:  message,
+			Priority: priority,
+			Extras: map[string]interface{}{
+				"laundry-notify::topic": topic,
+			},
+		},
+	)
+}
@@ -0,0 +1,52 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	laundryNotify "jallier/laundry-notify"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestGotifyNotifier_NotifyWithOptions(t *testing.T) {
+	for _, tt := range []struct {
+		priority string
+		want     int
+	}{
+		{"", 5},
+		{laundryNotify.PRIORITY_LOW, 2},
+		{laundryNotify.PRIORITY_HIGH, 8},
+	} {
+		t.Run(tt.priority, func(t *testing.T) {
+			var got gotifyPayload
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/message" {
+					t.Errorf("path = %s, want /message", r.URL.Path)
+				}
+				if v := r.Header.Get("X-Gotify-Key"); v != "token" {
+					t.Errorf("token = %q, want token", v)
+				}
+				if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+					t.Errorf("decode body: %v", err)
+				}
+			}))
+			defer server.Close()
+
+			n := NewGotifyNotifier(server.URL+"/", "token", nil)
+			if err := n.NotifyWithOptions(context.Background(), "alice", "title", "message", laundryNotify.NotifyOptions{Priority: tt.priority}); err != nil {
+				t.Fatal(err)
+			}
+
+			if got.Title != "title" || got.Message != "message" {
+				t.Errorf("payload = %+v", got)
+			}
+			if got.Priority != tt.want {
+				t.Errorf("priority = %d, want %d", got.Priority, tt.want)
+			}
+			if got.Extras["laundry-notify::topic"] != "alice" {
+				t.Errorf("extras = %v, want the topic", got.Extras)
+			}
+		})
+	}
+}
@@ -0,0 +1,64 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	laundryNotify "jallier/laundry-notify"
+)
+
+// Ensure type implements interface.
+var _ laundryNotify.LaundryNotifyService = (*Registry)(nil)
+var _ laundryNotify.OptionsNotifyService = (*Registry)(nil)
+
+// Registry holds the notifier backends that are enabled, keyed by name. It implements
+// LaundryNotifyService itself by sending through the default backend.
+type Registry struct {
+	backends map[string]laundryNotify.LaundryNotifyService
+	// Name of the backend used by Notify
+	Default string
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		backends: make(map[string]laundryNotify.LaundryNotifyService),
+	}
+}
+
+// Register adds a backend. The first backend registered becomes the default
+func (r *Registry) Register(name string, backend laundryNotify.LaundryNotifyService) {
+	r.backends[name] = backend
+	if r.Default == "" {
+		r.Default = name
+	}
+}
+
+// Get returns the backend with the given name, or nil if it isn't enabled
+func (r *Registry) Get(name string) laundryNotify.LaundryNotifyService {
+	return r.backends[name]
+}
+
+// Notify sends the notification using the default backend
+func (r *Registry) Notify(ctx context.Context, topic string, title string, message string) error {
+	return r.Send(ctx, "", topic, title, message, laundryNotify.NotifyOptions{})
+}
+
+// NotifyWithOptions sends the notification using the default backend
+func (r *Registry) NotifyWithOptions(ctx context.Context, topic string, title string, message string, options laundryNotify.NotifyOptions) error {
+	return r.Send(ctx, "", topic, title, message, options)
+}
+
+// Send sends the notification using the named backend, or the default backend if name is empty.
+// Options are dropped for backends that don't support them.
+func (r *Registry) Send(ctx context.Context, name string, topic string, title string, message string, options laundryNotify.NotifyOptions) error {
+	if name == "" {
+		name = r.Default
+	}
+	backend := r.Get(name)
+	if backend == nil {
+		return fmt.Errorf("no notifier backend registered for %q", name)
+	}
+	if backend, ok := backend.(laundryNotify.OptionsNotifyService); ok {
+		return backend.NotifyWithOptions(ctx, topic, title, message, options)
+	}
+	return backend.Notify(ctx, topic, title, message)
+}
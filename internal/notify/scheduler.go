@@ -0,0 +1,175 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	laundryNotify "jallier/laundry-notify"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/log"
+)
+
+const DefaultSchedulerInterval = 30 * time.Second
+
+// Scheduler queues reminders for laundry that hasn't been collected once they are due. Reminders are
+// stored in the database, so any that came due while the service was stopped are sent on startup.
+// It also queues almost done notifications for cycles that are close to their estimated finish.
+type Scheduler struct {
+	reminderService laundryNotify.ReminderService
+	eventService    laundryNotify.EventService
+	appliances      laundryNotify.Appliances
+
+	Interval time.Duration
+	// How long before the estimated finish to send almost done notifications. Zero disables them
+	AlmostDone time.Duration
+
+	// Now returns the current time. Defaults to time.Now
+	Now func() time.Time
+
+	ctx    context.Context
+	cancel func()
+	done   chan struct{}
+}
+
+func NewScheduler(
+	reminderService laundryNotify.ReminderService,
+	eventService laundryNotify.EventService,
+	appliances laundryNotify.Appliances,
+) *Scheduler {
+	s := &Scheduler{
+		reminderService: reminderService,
+		eventService:    eventService,
+		appliances:      appliances,
+		Interval:        DefaultSchedulerInterval,
+		Now:             time.Now,
+		done:            make(chan struct{}),
+	}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	return s
+}
+
+// Open starts checking for due reminders in a background goroutine
+func (s *Scheduler) Open() {
+	go func() {
+		defer close(s.done)
+
+		ticker := time.NewTicker(s.Interval)
+		defer ticker.Stop()
+
+		for {
+			s.sendDueReminders()
+			if s.AlmostDone > 0 {
+				s.sendAlmostDone()
+			}
+			select {
+			case <-ticker.C:
+			case <-s.ctx.Done():
+				return
+			}
+		}
+	}()
+	log.Debug("reminder scheduler started", "interval", s.Interval)
+}
+
+// Close stops the scheduler
+func (s *Scheduler) Close() error {
+	s.cancel()
+	<-s.done
+	return nil
+}
+
+func (s *Scheduler) sendDueReminders() {
+	reminders, _, err := s.reminderService.FindReminders(s.ctx, laundryNotify.ReminderFilter{
+		Pending: true,
+		DueBy:   s.Now(),
+	})
+	if err != nil {
+		log.Error("Error finding due reminders", "error", err)
+		return
+	}
+
+	for _, reminder := range reminders {
+		if s.ctx.Err() != nil {
+			return
+		}
+		if err := s.sendReminder(reminder); err != nil {
+			log.Error("Error sending reminder", "id", reminder.Id, "error", err)
+		}
+	}
+}
+
+// sendReminder queues the reminder, unless the next cycle of the appliance has already started, in
+// which case the laundry must have been collected
+func (s *Scheduler) sendReminder(reminder *laundryNotify.Reminder) error {
+	mostRecentEvent, err := s.eventService.FindMostRecentEvent(s.ctx, reminder.Type)
+	if err != nil {
+		return err
+	}
+	if mostRecentEvent != nil && mostRecentEvent.Id != reminder.EventId {
+		log.Debug("Next cycle has started, cancelling reminder", "id", reminder.Id, "type", reminder.Type)
+		return s.reminderService.CancelReminder(s.ctx, reminder.Id)
+	}
+
+	event, err := s.eventService.FindEventById(s.ctx, reminder.EventId)
+	if err != nil {
+		return err
+	}
+
+	name := reminder.Type
+	if appliance := s.appliances.Find(reminder.Type); appliance != nil {
+		name = appliance.Name
+	}
+
+	err = s.reminderService.SendReminder(s.ctx, reminder.Id, laundryNotify.Notification{
+		Title: fmt.Sprintf("%s reminder", name),
+		Message: fmt.Sprintf(
+			"Your laundry is still in the %s. It finished at %s.",
+			strings.ToLower(name),
+			event.FinishedAt.Time.Local().Format("3:04pm"),
+		),
+	})
+	if err != nil {
+		return err
+	}
+	log.Info("Reminder queued", "id", reminder.Id, "user_id", reminder.UserId, "event_id", reminder.EventId)
+	return nil
+}
+
+// sendAlmostDone queues almost done notifications for any running cycle that is within AlmostDone of
+// its estimated finish
+func (s *Scheduler) sendAlmostDone() {
+	for _, appliance := range s.appliances {
+		if s.ctx.Err() != nil {
+			return
+		}
+
+		event, err := s.eventService.FindMostRecentEvent(s.ctx, appliance.Id)
+		if err != nil {
+			log.Error("Error finding most recent event", "type", appliance.Id, "error", err)
+			continue
+		} else if event == nil || event.FinishedAt.Valid || event.AlmostDoneAt.Valid {
+			continue
+		}
+
+		stats, err := s.eventService.FindEventStats(s.ctx, appliance.Id, event.StartedAt.Time)
+		if err != nil {
+			log.Error("Error finding event stats", "type", appliance.Id, "error", err)
+			continue
+		}
+		estimate, ok := stats.EstimatedFinish(event.StartedAt.Time)
+		if !ok || s.Now().Before(estimate.Add(-s.AlmostDone)) {
+			continue
+		}
+
+		notifications, err := s.eventService.NotifyAlmostDone(s.ctx, event.Id, laundryNotify.Notification{
+			Title:   fmt.Sprintf("%s almost done", appliance.Name),
+			Message: fmt.Sprintf("Your laundry should be ready at about %s.", estimate.Local().Format("3:04pm")),
+		})
+		if err != nil {
+			log.Error("Error queueing almost done notifications", "event_id", event.Id, "error", err)
+			continue
+		}
+		log.Info("Almost done notifications queued", "event_id", event.Id, "estimated_finish", estimate, "notifications", len(notifications))
+	}
+}
@@ -0,0 +1,150 @@
+package notify
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	laundryNotify "jallier/laundry-notify"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Ensure type implements interface.
+var _ laundryNotify.LaundryNotifyService = (*EmailNotifier)(nil)
+var _ laundryNotify.OptionsNotifyService = (*EmailNotifier)(nil)
+
+// EmailNotifier sends notifications as plain text emails over smtp
+type EmailNotifier struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+	// Recipient address. `{topic}` is replaced with the notification topic, eg `{topic}@example.com`.
+	// Topics that are already email addresses are used as is.
+	To string
+	// How long sending an email can take. Defaults to DefaultHttpTimeout
+	Timeout time.Duration
+}
+
+func NewEmailNotifier(host string, port int, username string, password string, from string, to string) *EmailNotifier {
+	return &EmailNotifier{
+		Host:     host,
+		Port:     port,
+		Username: username,
+		Password: password,
+		From:     from,
+		To:       to,
+		Timeout:  DefaultHttpTimeout,
+	}
+}
+
+func (n *EmailNotifier) Notify(ctx context.Context, topic string, title string, message string) error {
+	return n.NotifyWithOptions(ctx, topic, title, message, laundryNotify.NotifyOptions{})
+}
+
+func (n *EmailNotifier) NotifyWithOptions(ctx context.Context, topic string, title string, message string, options laundryNotify.NotifyOptions) error {
+	to := n.recipient(topic)
+	if to == "" {
+		return fmt.Errorf("no email recipient for topic %q", topic)
+	}
+
+	var auth smtp.Auth
+	if n.Username != "" {
+		auth = smtp.PlainAuth("", n.Username, n.Password, n.Host)
+	}
+
+	return n.send(ctx, auth, to, n.message(to, title, message, options))
+}
+
+// send is smtp.SendMail, but the connection is closed once the context is done or the timeout passes, so
+// an unresponsive server can't hang the dispatcher
+func (n *EmailNotifier) send(ctx context.Context, auth smtp.Auth, to string, msg []byte) error {
+	if n.Timeout > 0 {
+		var cancel func()
+		ctx, cancel = context.WithTimeout(ctx, n.Timeout)
+		defer cancel()
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(n.Host, strconv.Itoa(n.Port)))
+	if err != nil {
+		return err
+	}
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	c, err := smtp.NewClient(conn, n.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: n.Host}); err != nil {
+			return err
+		}
+	}
+	if auth != nil {
+		if ok, _ := c.Extension("AUTH"); ok {
+			if err := c.Auth(auth); err != nil {
+				return err
+			}
+		}
+	}
+	if err := c.Mail(n.From); err != nil {
+		return err
+	}
+	if err := c.Rcpt(to); err != nil {
+		return err
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+func (n *EmailNotifier) recipient(topic string) string {
+	if strings.Contains(topic, "@") {
+		return topic
+	}
+	return strings.ReplaceAll(n.To, "{topic}", topic)
+}
+
+func (n *EmailNotifier) message(to string, title string, message string, options laundryNotify.NotifyOptions) []byte {
+	// Strip newlines so the subject can't inject headers
+	stripNewlines := strings.NewReplacer("\r", " ", "\n", " ")
+	title = stripNewlines.Replace(title)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", n.From)
+	fmt.Fprintf(&b, "To: %s\r\n", to)
+	fmt.Fprintf(&b, "Subject: %s\r\n", title)
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	if options.Language != "" {
+		fmt.Fprintf(&b, "Content-Language: %s\r\n", stripNewlines.Replace(options.Language))
+	}
+	switch options.Priority {
+	case laundryNotify.PRIORITY_LOW:
+		b.WriteString("X-Priority: 5\r\n")
+	case laundryNotify.PRIORITY_HIGH:
+		b.WriteString("X-Priority: 1\r\n")
+	}
+	b.WriteString("\r\n")
+	b.WriteString(message)
+	b.WriteString("\r\n")
+	return []byte(b.String())
+}
@@ -0,0 +1,161 @@
+package notify
+
+import (
+	"bufio"
+	"context"
+	laundryNotify "jallier/laundry-notify"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// smtpServer is a minimal smtp server that accepts a single message
+type smtpServer struct {
+	listener net.Listener
+	// Stops responding after the greeting, to simulate a hung server
+	hang bool
+
+	rcpt chan string
+	data chan string
+}
+
+func newSmtpServer(t *testing.T, hang bool) *smtpServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &smtpServer{listener: listener, hang: hang, rcpt: make(chan string, 1), data: make(chan string, 1)}
+	t.Cleanup(func() { listener.Close() })
+	go s.serve()
+	return s
+}
+
+func (s *smtpServer) hostPort() (string, int) {
+	addr := s.listener.Addr().(*net.TCPAddr)
+	return addr.IP.String(), addr.Port
+}
+
+func (s *smtpServer) serve() {
+	conn, err := s.listener.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+	reply("220 localhost ready")
+	if s.hang {
+		// Wait for the client to give up
+		r.ReadString('\n')
+		conn.Read(make([]byte, 1))
+		return
+	}
+
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		cmd := strings.ToUpper(strings.TrimSpace(line))
+		switch {
+		case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+			reply("250 localhost")
+		case strings.HasPrefix(cmd, "MAIL FROM"):
+			reply("250 ok")
+		case strings.HasPrefix(cmd, "RCPT TO"):
+			s.rcpt <- strings.Trim(strings.TrimSpace(line)[len("RCPT TO:"):], "<>")
+			reply("250 ok")
+		case cmd == "DATA":
+			reply("354 go ahead")
+			var b strings.Builder
+			for {
+				line, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if line == ".\r\n" {
+					break
+				}
+				b.WriteString(line)
+			}
+			s.data <- b.String()
+			reply("250 ok")
+		case cmd == "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("502 unknown command")
+		}
+	}
+}
+
+func TestEmailNotifier_NotifyWithOptions(t *testing.T) {
+	server := newSmtpServer(t, false)
+	host, port := server.hostPort()
+
+	n := NewEmailNotifier(host, port, "", "", "laundry@example.com", "{topic}@example.com")
+	err := n.NotifyWithOptions(context.Background(), "alice", "Washer\r\nBcc: evil@example.com", "Your laundry is ready!", laundryNotify.NotifyOptions{
+		Priority: laundryNotify.PRIORITY_HIGH,
+		Language: "en",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rcpt := <-server.rcpt; rcpt != "alice@example.com" {
+		t.Errorf("recipient = %q, want alice@example.com", rcpt)
+	}
+	data := <-server.data
+	for _, want := range []string{
+		"To: alice@example.com\r\n",
+		"Subject: Washer  Bcc: evil@example.com\r\n",
+		"Content-Language: en\r\n",
+		"X-Priority: 1\r\n",
+		"\r\n\r\nYour laundry is ready!\r\n",
+	} {
+		if !strings.Contains(data, want) {
+			t.Errorf("message is missing %q:\n%s", want, data)
+		}
+	}
+}
+
+func TestEmailNotifier_TopicIsAddress(t *testing.T) {
+	server := newSmtpServer(t, false)
+	host, port := server.hostPort()
+
+	n := NewEmailNotifier(host, port, "", "", "laundry@example.com", "{topic}@example.com")
+	if err := n.Notify(context.Background(), "bob@example.org", "title", "message"); err != nil {
+		t.Fatal(err)
+	}
+	if rcpt := <-server.rcpt; rcpt != "bob@example.org" {
+		t.Errorf("recipient = %q, want bob@example.org", rcpt)
+	}
+}
+
+func TestEmailNotifier_HungServer(t *testing.T) {
+	server := newSmtpServer(t, true)
+	host, port := server.hostPort()
+
+	n := NewEmailNotifier(host, port, "", "", "laundry@example.com", "{topic}@example.com")
+	n.Timeout = 50 * time.Millisecond
+
+	done := make(chan error, 1)
+	go func() { done <- n.Notify(context.Background(), "alice", "title", "message") }()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Fatal("expected an error from a hung server")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("notify didn't give up on a hung server")
+	}
+}
+
+func TestEmailNotifier_NoRecipient(t *testing.T) {
+	n := NewEmailNotifier("localhost", 25, "", "", "laundry@example.com", "")
+	if err := n.Notify(context.Background(), "alice", "title", "message"); err == nil {
+		t.Fatal("expected an error without a recipient")
+	}
+}
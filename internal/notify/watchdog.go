@@ -0,0 +1,149 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	laundryNotify "jallier/laundry-notify"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/log"
+)
+
+const DefaultWatchdogInterval = time.Minute
+
+// How long the watchdog waits after opening before its first check, so messages the mqtt broker kept while
+// the service was down can be handled first
+const DefaultWatchdogStartDelay = 2 * time.Minute
+
+// Watchdog finishes events that have been open for longer than their appliance's longest cycle, as their
+// finish message must have been missed, and a new cycle can't start until the previous one has finished.
+// Subscribers are told the finish was inferred, and an admin topic is alerted so the cause can be checked.
+type Watchdog struct {
+	eventService        laundryNotify.EventService
+	notificationService laundryNotify.NotificationService
+	eventBus            laundryNotify.EventBus
+	appliances          laundryNotify.Appliances
+
+	Interval time.Duration
+	// How long to wait before the first check
+	StartDelay time.Duration
+	// Topic that is alerted whenever an event times out. Disabled if empty
+	AdminTopic string
+
+	// Now returns the current time. Defaults to time.Now
+	Now func() time.Time
+
+	ctx    context.Context
+	cancel func()
+	done   chan struct{}
+}
+
+func NewWatchdog(
+	eventService laundryNotify.EventService,
+	notificationService laundryNotify.NotificationService,
+	eventBus laundryNotify.EventBus,
+	appliances laundryNotify.Appliances,
+) *Watchdog {
+	w := &Watchdog{
+		eventService:        eventService,
+		notificationService: notificationService,
+		eventBus:            eventBus,
+		appliances:          appliances,
+		Interval:            DefaultWatchdogInterval,
+		StartDelay:          DefaultWatchdogStartDelay,
+		Now:                 time.Now,
+		done:                make(chan struct{}),
+	}
+	w.ctx, w.cancel = context.WithCancel(context.Background())
+	return w
+}
+
+// Open starts checking for timed out events in a background goroutine, after StartDelay
+func (w *Watchdog) Open() {
+	go func() {
+		defer close(w.done)
+
+		select {
+		case <-time.After(w.StartDelay):
+		case <-w.ctx.Done():
+			return
+		}
+
+		ticker := time.NewTicker(w.Interval)
+		defer ticker.Stop()
+
+		for {
+			w.timeOutEvents()
+			select {
+			case <-ticker.C:
+			case <-w.ctx.Done():
+				return
+			}
+		}
+	}()
+	log.Debug("event watchdog started", "interval", w.Interval, "start_delay", w.StartDelay)
+}
+
+// Close stops the watchdog
+func (w *Watchdog) Close() error {
+	w.cancel()
+	<-w.done
+	return nil
+}
+
+func (w *Watchdog) timeOutEvents() {
+	for _, appliance := range w.appliances {
+		if w.ctx.Err() != nil {
+			return
+		}
+
+		events, _, err := w.eventService.FindEvents(w.ctx, laundryNotify.EventFilter{
+			Type:          &appliance.Id,
+			StartedBefore: w.Now().Add(-appliance.MaxCycle),
+			Unfinished:    true,
+		})
+		if err != nil {
+			log.Error("Error finding open events", "type", appliance.Id, "error", err)
+			continue
+		}
+
+		for _, event := range events {
+			if err := w.timeOut(appliance, event); err != nil {
+				log.Error("Error timing out event", "id", event.Id, "error", err)
+			}
+		}
+	}
+}
+
+// timeOut finishes the event, notifying its subscribers and scheduling the appliance's reminders for
+// them, and alerts the admin topic
+func (w *Watchdog) timeOut(appliance *laundryNotify.Appliance, event *laundryNotify.Event) error {
+	event, notifications, err := w.eventService.TimeOutEvent(w.ctx, event.Id, w.Now(), laundryNotify.Notification{
+		Title: fmt.Sprintf("%s event finished", appliance.Name),
+		Message: fmt.Sprintf(
+			"Your laundry should be ready! The %s never reported finishing, so it probably finished a while ago.",
+			strings.ToLower(appliance.Name),
+		),
+	}, appliance.Reminders)
+	if err != nil {
+		return err
+	}
+	log.Warn("Event timed out", "type", appliance.Id, "id", event.Id, "started_at", event.StartedAt.Time, "notifications", len(notifications))
+	w.eventBus.Publish(laundryNotify.ApplianceUpdate{Kind: laundryNotify.EVENT_FINISHED, Event: event})
+
+	if w.AdminTopic == "" {
+		return nil
+	}
+	return w.notificationService.CreateNotification(w.ctx, &laundryNotify.Notification{
+		EventId: event.Id,
+		Topic:   w.AdminTopic,
+		Title:   fmt.Sprintf("%s cycle timed out", appliance.Name),
+		Message: fmt.Sprintf(
+			"The cycle that started at %s was still open after %s, so it was finished automatically. Check the %s is publishing its finish messages.",
+			event.StartedAt.Time.Local().Format("Mon 3:04pm"),
+			appliance.MaxCycle,
+			strings.ToLower(appliance.Name),
+		),
+	})
+}
@@ -0,0 +1,107 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	laundryNotify "jallier/laundry-notify"
+	"net/http"
+	neturl "net/url"
+	"strings"
+	"time"
+)
+
+// DefaultHttpTimeout limits how long a request to a notifier backend can take, so a hung endpoint can't
+// hold up the dispatcher
+const DefaultHttpTimeout = 10 * time.Second
+
+// Ensure type implements interface.
+var _ laundryNotify.LaundryNotifyService = (*WebhookNotifier)(nil)
+var _ laundryNotify.OptionsNotifyService = (*WebhookNotifier)(nil)
+
+// WebhookNotifier posts notifications as json to a url. Topics that are urls themselves, set by users
+// in their preferences, are posted to instead, as long as they are on one of the allowed hosts.
+type WebhookNotifier struct {
+	URL string
+	// Hosts that user set urls may be on. Defaults to the host of URL
+	AllowedHosts []string
+	HttpClient   *http.Client
+}
+
+func NewWebhookNotifier(url string, client *http.Client) *WebhookNotifier {
+	if client == nil {
+		client = newHttpClient()
+		// Redirects could send the post on to a host that isn't allowed
+		client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		}
+	}
+	n := &WebhookNotifier{URL: url, HttpClient: client}
+	if u, err := neturl.Parse(url); err == nil && u.Hostname() != "" {
+		n.AllowedHosts = []string{u.Hostname()}
+	}
+	return n
+}
+
+type webhookPayload struct {
+	Topic    string `json:"topic"`
+	Title    string `json:"title"`
+	Message  string `json:"message"`
+	Priority string `json:"priority,omitempty"`
+	Language string `json:"language,omitempty"`
+}
+
+func (n *WebhookNotifier) Notify(ctx context.Context, topic string, title string, message string) error {
+	return n.NotifyWithOptions(ctx, topic, title, message, laundryNotify.NotifyOptions{})
+}
+
+func (n *WebhookNotifier) NotifyWithOptions(ctx context.Context, topic string, title string, message string, options laundryNotify.NotifyOptions) error {
+	url := n.URL
+	if strings.HasPrefix(topic, "http://") || strings.HasPrefix(topic, "https://") {
+		if !laundryNotify.WebhookTargetAllowed(topic, n.AllowedHosts) {
+			return fmt.Errorf("webhook url is not on an allowed host: %s", topic)
+		}
+		url = topic
+	}
+	return postJson(ctx, n.HttpClient, url, nil, webhookPayload{
+		Topic:    topic,
+		Title:    title,
+		Message:  message,
+		Priority: options.Priority,
+		Language: options.Language,
+	})
+}
+
+// newHttpClient returns the client used by backends that weren't given one
+func newHttpClient() *http.Client {
+	return &http.Client{Timeout: DefaultHttpTimeout}
+}
+
+// postJson posts the body as json, returning an error for non 2xx responses
+func postJson(ctx context.Context, client *http.Client, url string, headers map[string]string, body interface{}) error {
+	buf, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(buf))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected response status from %s: %s", req.URL.Host, resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	laundryNotify "jallier/laundry-notify"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestWebhookNotifier_NotifyWithOptions(t *testing.T) {
+	var got webhookPayload
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			t.Errorf("method = %s, want POST", r.Method)
+		}
+		if v := r.Header.Get("Content-Type"); v != "application/json" {
+			t.Errorf("content type = %q, want application/json", v)
+		}
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("decode body: %v", err)
+		}
+	}))
+	defer server.Close()
+
+	n := NewWebhookNotifier(server.URL, nil)
+	err := n.NotifyWithOptions(context.Background(), "alice", "Washer event finished", "Your laundry is ready!", laundryNotify.NotifyOptions{
+		Priority: laundryNotify.PRIORITY_HIGH,
+		Language: "en",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := webhookPayload{Topic: "alice", Title: "Washer event finished", Message: "Your laundry is ready!", Priority: "high", Language: "en"}
+	if got != want {
+		t.Errorf("payload = %+v, want %+v", got, want)
+	}
+}
+
+func TestWebhookNotifier_ErrorStatus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	if err := NewWebhookNotifier(server.URL, nil).Notify(context.Background(), "alice", "title", "message"); err == nil {
+		t.Fatal("expected an error for a 500 response")
+	}
+}
+
+func TestWebhookNotifier_HungEndpoint(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+
+	t.Run("Timeout", func(t *testing.T) {
+		n := NewWebhookNotifier(server.URL, &http.Client{Timeout: 50 * time.Millisecond})
+		if err := n.Notify(context.Background(), "alice", "title", "message"); err == nil {
+			t.Fatal("expected the request to time out")
+		}
+	})
+
+	t.Run("Cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(50*time.Millisecond, cancel)
+
+		if err := NewWebhookNotifier(server.URL, nil).Notify(ctx, "alice", "title", "message"); err == nil {
+			t.Fatal("expected the request to be cancelled")
+		}
+	})
+}
+
+func TestNewWebhookNotifier_DefaultTimeout(t *testing.T) {
+	if n := NewWebhookNotifier("http://localhost", nil); n.HttpClient.Timeout != DefaultHttpTimeout {
+		t.Errorf("timeout = %s, want %s", n.HttpClient.Timeout, DefaultHttpTimeout)
+	}
+}
+
+func TestWebhookNotifier_UserTarget(t *testing.T) {
+	var paths []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		if r.URL.Path == "/redirect" {
+			http.Redirect(w, r, "http://169.254.169.254/latest", http.StatusTemporaryRedirect)
+		}
+	}))
+	defer server.Close()
+
+	n := NewWebhookNotifier(server.URL+"/hook", nil)
+
+	if err := n.Notify(context.Background(), server.URL+"/alice", "title", "message"); err != nil {
+		t.Fatalf("url on the webhook's host: %v", err)
+	}
+	if err := n.Notify(context.Background(), "http://169.254.169.254/latest", "title", "message"); err == nil {
+		t.Error("expected a url on another host to be refused")
+	}
+	if err := n.Notify(context.Background(), server.URL+"/redirect", "title", "message"); err == nil {
+		t.Error("expected a redirect not to be followed")
+	}
+
+	if want := []string{"/alice", "/redirect"}; len(paths) != len(want) || paths[0] != want[0] || paths[1] != want[1] {
+		t.Errorf("requested paths = %v, want %v", paths, want)
+	}
+}
@@ -5,11 +5,15 @@ import (
 	"fmt"
 	"net/http"
 	"net/url"
+	"time"
 
 	"github.com/AnthonyHewins/gotfy"
 	"github.com/charmbracelet/log"
 )
 
+// DefaultTimeout limits how long publishing a message can take when no http client is given
+const DefaultTimeout = 10 * time.Second
+
 type NtfyManager struct {
 	NtfyServer    string
 	HttpClient    *http.Client
@@ -26,7 +30,7 @@ func NewNtfyManager(ntfyServer string, client *http.Client) *NtfyManager {
 	}
 
 	if client == nil {
-		client = http.DefaultClient
+		client = &http.Client{Timeout: DefaultTimeout}
 	}
 
 	publisher, err := gotfy.NewPublisher(server, client)
@@ -62,7 +66,7 @@ func (m *NtfyManager) Connect() error {
 	}
 
 	if m.HttpClient == nil {
-		m.HttpClient = http.DefaultClient
+		m.HttpClient = &http.Client{Timeout: DefaultTimeout}
 	}
 
 	m.ntfyPublisher, err = gotfy.NewPublisher(server, m.HttpClient)
@@ -74,8 +78,14 @@ func (m *NtfyManager) Connect() error {
 	return nil
 }
 
-func (m *NtfyManager) Notify(message *gotfy.Message) error {
-	pubResp, err := m.ntfyPublisher.SendMessage(m.ctx, message)
+// Notify publishes the message, giving up once either the context or the manager is done
+func (m *NtfyManager) Notify(ctx context.Context, message *gotfy.Message) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stop := context.AfterFunc(m.ctx, cancel)
+	defer stop()
+
+	pubResp, err := m.ntfyPublisher.SendMessage(ctx, message)
 
 	if err != nil {
 		return err
@@ -1,12 +1,14 @@
 package ntfy
 
 import (
+	"context"
 	laundryNotify "jallier/laundry-notify"
 
 	"github.com/AnthonyHewins/gotfy"
 )
 
 var _ laundryNotify.LaundryNotifyService = (*LaundryNotifyService)(nil)
+var _ laundryNotify.OptionsNotifyService = (*LaundryNotifyService)(nil)
 
 type LaundryNotifyService struct {
 	ntfyManager *NtfyManager
@@ -16,13 +18,30 @@ func NewLaundryNotifyService(ntfyManager *NtfyManager) *LaundryNotifyService {
 	return &LaundryNotifyService{ntfyManager: ntfyManager}
 }
 
-func (s *LaundryNotifyService) Notify(topic string, title string, message string) error {
+func (s *LaundryNotifyService) Notify(ctx context.Context, topic string, title string, message string) error {
+	return s.NotifyWithOptions(ctx, topic, title, message, laundryNotify.NotifyOptions{})
+}
+
+func (s *LaundryNotifyService) NotifyWithOptions(ctx context.Context, topic string, title string, message string, options laundryNotify.NotifyOptions) error {
 	fullTopic := s.ntfyManager.BaseTopic + "-" + topic
 	messageStruct := &gotfy.Message{
-		Topic:   fullTopic,
-		Title:   title,
-		Message: message,
+		Topic:    fullTopic,
+		Title:    title,
+		Message:  message,
+		Priority: priority(options.Priority),
 	}
 
-	return s.ntfyManager.Notify(messageStruct)
+	return s.ntfyManager.Notify(ctx, messageStruct)
+}
+
+func priority(priority string) gotfy.Priority {
+	switch priority {
+	case laundryNotify.PRIORITY_LOW:
+		return gotfy.Low
+	case laundryNotify.PRIORITY_DEFAULT:
+		return gotfy.Default
+	case laundryNotify.PRIORITY_HIGH:
+		return gotfy.High
+	}
+	return gotfy.UnspecifiedPriority
 }
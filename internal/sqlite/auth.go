@@ -0,0 +1,200 @@
+package sqlite
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	laundryNotify "jallier/laundry-notify"
+	"unicode/utf8"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Ensure service implements interface.
+var _ laundryNotify.AuthService = (*AuthService)(nil)
+
+type AuthService struct {
+	db *DB
+}
+
+func NewAuthService(db *DB) *AuthService {
+	return &AuthService{db: db}
+}
+
+func (s *AuthService) SetPin(ctx context.Context, userId int, pin string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := setPin(ctx, tx, userId, pin); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *AuthService) AuthenticatePin(ctx context.Context, userId int, pin string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var hash sql.NullString
+	if err := tx.QueryRowContext(ctx, `SELECT pin_hash FROM users WHERE id = ?`, userId).Scan(&hash); err == sql.ErrNoRows {
+		return laundryNotify.Errorf(laundryNotify.EUNAUTHORIZED, "Incorrect name or PIN.")
+	} else if err != nil {
+		return err
+	}
+
+	if !hash.Valid || bcrypt.CompareHashAndPassword([]byte(hash.String), []byte(pin)) != nil {
+		return laundryNotify.Errorf(laundryNotify.EUNAUTHORIZED, "Incorrect name or PIN.")
+	}
+	return nil
+}
+
+func (s *AuthService) CredentialVersion(ctx context.Context, userId int) (int, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var version int
+	if err := tx.QueryRowContext(ctx, `SELECT credential_version FROM users WHERE id = ?`, userId).Scan(&version); err == sql.ErrNoRows {
+		return 0, laundryNotify.Errorf(laundryNotify.ENOTFOUND, "User not found: %d", userId)
+	} else if err != nil {
+		return 0, err
+	}
+	return version, nil
+}
+
+func (s *AuthService) CreateLoginToken(ctx context.Context, userId int) (string, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	token, err := createLoginToken(ctx, tx, userId)
+	if err != nil {
+		return "", err
+	}
+
+	return token, tx.Commit()
+}
+
+func (s *AuthService) RedeemLoginToken(ctx context.Context, token string) (int, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	userId, err := redeemLoginToken(ctx, tx, token)
+	if err != nil {
+		return 0, err
+	}
+
+	return userId, tx.Commit()
+}
+
+func setPin(ctx context.Context, tx *Tx, userId int, pin string) error {
+	if user, err := findUserById(ctx, tx, userId); err != nil {
+		return err
+	} else if user == nil {
+		return laundryNotify.Errorf(laundryNotify.ENOTFOUND, "User not found: %d", userId)
+	}
+
+	var hash sql.NullString
+	if pin != "" {
+		if utf8.RuneCountInString(pin) < laundryNotify.MIN_PIN_LENGTH {
+			return laundryNotify.Errorf(laundryNotify.EINVALID, "PIN must be at least %d characters.", laundryNotify.MIN_PIN_LENGTH)
+		}
+		// bcrypt ignores anything past 72 bytes
+		if len(pin) > 72 {
+			return laundryNotify.Errorf(laundryNotify.EINVALID, "PIN must be at most 72 bytes.")
+		}
+
+		b, err := bcrypt.GenerateFromPassword([]byte(pin), bcrypt.DefaultCost)
+		if err != nil {
+			return err
+		}
+		hash = sql.NullString{String: string(b), Valid: true}
+	}
+
+	_, err := tx.ExecContext(ctx, `
+		UPDATE users
+		SET pin_hash = ?, credential_version = credential_version + 1
+		WHERE id = ?
+		`,
+		hash,
+		userId,
+	)
+	return err
+}
+
+func createLoginToken(ctx context.Context, tx *Tx, userId int) (string, error) {
+	if user, err := findUserById(ctx, tx, userId); err != nil {
+		return "", err
+	} else if user == nil {
+		return "", laundryNotify.Errorf(laundryNotify.ENOTFOUND, "User not found: %d", userId)
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+
+	createdAt := sql.NullTime{Time: tx.now, Valid: true}
+	expiresAt := sql.NullTime{Time: tx.now.Add(laundryNotify.LOGIN_TOKEN_LIFETIME), Valid: true}
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO login_tokens (user_id, token_hash, expires_at, created_at)
+		VALUES (?, ?, ?, ?)
+		`,
+		userId,
+		hashLoginToken(token),
+		(*NullTime)(&expiresAt),
+		(*NullTime)(&createdAt),
+	)
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+func redeemLoginToken(ctx context.Context, tx *Tx, token string) (int, error) {
+	now := sql.NullTime{Time: tx.now, Valid: true}
+	var userId int
+	err := tx.QueryRowContext(ctx, `
+		UPDATE login_tokens
+		SET used_at = ?
+		WHERE token_hash = ? AND used_at IS NULL AND expires_at > ?
+		RETURNING user_id
+		`,
+		(*NullTime)(&now),
+		hashLoginToken(token),
+		(*NullTime)(&now),
+	).Scan(&userId)
+	if err == sql.ErrNoRows {
+		return 0, laundryNotify.Errorf(laundryNotify.EUNAUTHORIZED, "Sign in link is invalid or has expired.")
+	} else if err != nil {
+		return 0, err
+	}
+
+	return userId, nil
+}
+
+// hashLoginToken returns the hash stored for a token, so a leaked database can't be used to sign in.
+// Tokens are random, so don't need a slow hash.
+func hashLoginToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -2,10 +2,23 @@ package sqlite
 
 import (
 	"context"
+	"database/sql"
 	laundryNotify "jallier/laundry-notify"
+	"math"
+	"slices"
 	"strings"
+	"time"
 )
 
+// How many recent cycles are used to calculate duration stats
+const eventStatsSampleSize = 100
+
+// Minimum number of cycles at a time of day before they're used instead of cycles from any time
+const eventStatsTimeOfDayMin = 5
+
+// Columns events can be ordered by
+var eventOrderByColumns = []string{"id", "type", "started_at", "finished_at"}
+
 // Ensure service implements interface.
 var _ laundryNotify.EventService = (*EventService)(nil)
 
@@ -43,33 +56,266 @@ func (s *EventService) FindMostRecentEvent(ctx context.Context, eventType string
 	return event, nil
 }
 
-func (s *EventService) CreateEvent(ctx context.Context, event *laundryNotify.Event) error {
+func (s *EventService) FindEvents(ctx context.Context, filter laundryNotify.EventFilter) ([]*laundryNotify.Event, int, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer tx.Rollback()
+	return findEvents(ctx, tx, filter)
+}
+
+func (s *EventService) StartEvent(ctx context.Context, eventType string, startedAt time.Time) (*laundryNotify.Event, []*laundryNotify.UserEvent, error) {
 	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 	defer tx.Rollback()
 
-	if err := createEvent(ctx, tx, event); err != nil {
-		return err
+	event, userEvents, err := startEvent(ctx, tx, eventType, startedAt)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	return tx.Commit()
+	return event, userEvents, tx.Commit()
 }
 
-func (s *EventService) UpdateEvent(ctx context.Context, id int, upd laundryNotify.EventUpdate) (*laundryNotify.Event, error) {
+func (s *EventService) FinishRunningEvent(ctx context.Context, eventType string, finishedAt time.Time, notification laundryNotify.Notification, remindAfter []time.Duration) (*laundryNotify.Event, []*laundryNotify.Notification, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	event, notifications, err := finishRunningEvent(ctx, tx, eventType, finishedAt, notification, remindAfter)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return event, notifications, tx.Commit()
+}
+
+func (s *EventService) FindEventStats(ctx context.Context, eventType string, startedAt time.Time) (*laundryNotify.EventStats, error) {
 	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
 		return nil, err
 	}
 	defer tx.Rollback()
+	return findEventStats(ctx, tx, eventType, startedAt)
+}
 
-	event, err := updateEvent(ctx, tx, id, upd)
+func (s *EventService) NotifyAlmostDone(ctx context.Context, id int, notification laundryNotify.Notification) ([]*laundryNotify.Notification, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
-		return event, err
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	event, err := findEventById(ctx, tx, id)
+	if err != nil {
+		return nil, err
+	}
+	if event.FinishedAt.Valid {
+		return nil, laundryNotify.Errorf(laundryNotify.ECONFLICT, "Event has already finished: %d", id)
+	}
+	if event.AlmostDoneAt.Valid {
+		return nil, laundryNotify.Errorf(laundryNotify.ECONFLICT, "Event has already been notified: %d", id)
+	}
+
+	notifications, err := createEventNotifications(ctx, tx, id, notification)
+	if err != nil {
+		return nil, err
+	}
+
+	almostDoneAt := sql.NullTime{Time: tx.now, Valid: true}
+	if _, err := tx.ExecContext(ctx, `UPDATE events SET almost_done_at = ? WHERE id = ?`, (*NullTime)(&almostDoneAt), id); err != nil {
+		return nil, err
 	}
 
-	return event, tx.Commit()
+	return notifications, tx.Commit()
+}
+
+func (s *EventService) FlagStaleEvents(ctx context.Context, eventType string, startedBefore time.Time) ([]*laundryNotify.Event, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	events, _, err := findEvents(ctx, tx, laundryNotify.EventFilter{
+		Type:          &eventType,
+		StartedBefore: startedBefore,
+		Unfinished:    true,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	staleAt := sql.NullTime{Time: tx.now, Valid: true}
+	flagged := make([]*laundryNotify.Event, 0, len(events))
+	for _, event := range events {
+		if event.StaleAt.Valid {
+			continue
+		}
+		if _, err := tx.ExecContext(ctx, `UPDATE events SET stale_at = ? WHERE id = ?`, (*NullTime)(&staleAt), event.Id); err != nil {
+			return nil, err
+		}
+		event.StaleAt = staleAt
+		flagged = append(flagged, event)
+	}
+
+	return flagged, tx.Commit()
+}
+
+func (s *EventService) TimeOutEvent(ctx context.Context, id int, finishedAt time.Time, notification laundryNotify.Notification, remindAfter []time.Duration) (*laundryNotify.Event, []*laundryNotify.Notification, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	event, err := findEventById(ctx, tx, id)
+	if err != nil {
+		return nil, nil, err
+	}
+	if event.FinishedAt.Valid {
+		return nil, nil, laundryNotify.Errorf(laundryNotify.ECONFLICT, "Event has already finished: %d", id)
+	}
+
+	event, notifications, err := finishEvent(ctx, tx, id, finishedAt, notification, remindAfter)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if _, err := tx.ExecContext(ctx, `UPDATE events SET timed_out = 1 WHERE id = ?`, id); err != nil {
+		return nil, nil, err
+	}
+	event.TimedOut = true
+
+	return event, notifications, tx.Commit()
+}
+
+// finishRunningEvent finishes the running event of the type. Returns ENOTFOUND if none is running
+func finishRunningEvent(ctx context.Context, tx *Tx, eventType string, finishedAt time.Time, notification laundryNotify.Notification, remindAfter []time.Duration) (*laundryNotify.Event, []*laundryNotify.Notification, error) {
+	event, err := findMostRecentEvent(ctx, tx, eventType)
+	if err != nil {
+		return nil, nil, err
+	} else if event == nil || event.FinishedAt.Valid {
+		return nil, nil, laundryNotify.Errorf(laundryNotify.ENOTFOUND, "No %s event is running.", eventType)
+	}
+
+	return finishEvent(ctx, tx, event.Id, finishedAt, notification, remindAfter)
+}
+
+// finishEvent sets the event's finish time, queues the notification for each subscriber and schedules a
+// reminder for each of them after each of the remindAfter durations
+func finishEvent(ctx context.Context, tx *Tx, id int, finishedAt time.Time, notification laundryNotify.Notification, remindAfter []time.Duration) (*laundryNotify.Event, []*laundryNotify.Notification, error) {
+	event, err := updateEvent(ctx, tx, id, laundryNotify.EventUpdate{
+		FinishedAt: sql.NullTime{Time: finishedAt, Valid: true},
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	notifications, err := createEventNotifications(ctx, tx, id, notification)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := createEventReminders(ctx, tx, event, notifications, remindAfter); err != nil {
+		return nil, nil, err
+	}
+
+	if _, err := chainUserEvents(ctx, tx, id); err != nil {
+		return nil, nil, err
+	}
+
+	return event, notifications, nil
+}
+
+// createEventNotifications queues a copy of the notification for each user subscribed to the event
+func createEventNotifications(ctx context.Context, tx *Tx, id int, notification laundryNotify.Notification) ([]*laundryNotify.Notification, error) {
+	userIds, err := findUserIdsByEventId(ctx, tx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	notifications := make([]*laundryNotify.Notification, 0, len(userIds))
+	for _, userId := range userIds {
+		n := notification
+		n.UserId, n.EventId = userId, id
+		if err := createNotification(ctx, tx, &n); err != nil {
+			return nil, err
+		}
+		notifications = append(notifications, &n)
+	}
+
+	return notifications, nil
+}
+
+func findEventStats(ctx context.Context, tx *Tx, eventType string, startedAt time.Time) (*laundryNotify.EventStats, error) {
+	rows, err := tx.QueryContext(ctx, `
+		SELECT
+			started_at,
+			finished_at
+		FROM events
+		WHERE type = ? AND finished_at IS NOT NULL AND timed_out = 0
+		ORDER BY started_at DESC
+		LIMIT ?
+		`,
+		eventType,
+		eventStatsSampleSize,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var all []time.Duration
+	byTimeOfDay := make(map[string][]time.Duration)
+	for rows.Next() {
+		var started, finished sql.NullTime
+		if err := rows.Scan((*NullTime)(&started), (*NullTime)(&finished)); err != nil {
+			return nil, err
+		}
+		d := finished.Time.Sub(started.Time)
+		if d <= 0 {
+			continue
+		}
+		all = append(all, d)
+		timeOfDay := laundryNotify.TimeOfDay(started.Time)
+		byTimeOfDay[timeOfDay] = append(byTimeOfDay[timeOfDay], d)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	stats := &laundryNotify.EventStats{Type: eventType}
+	durations := all
+	if !startedAt.IsZero() {
+		timeOfDay := laundryNotify.TimeOfDay(startedAt)
+		if len(byTimeOfDay[timeOfDay]) >= eventStatsTimeOfDayMin {
+			stats.TimeOfDay, durations = timeOfDay, byTimeOfDay[timeOfDay]
+		}
+	}
+	if len(durations) == 0 {
+		return stats, nil
+	}
+
+	slices.Sort(durations)
+	stats.Count = len(durations)
+	stats.Median = percentile(durations, 0.5)
+	stats.P90 = percentile(durations, 0.9)
+	return stats, nil
+}
+
+// percentile returns the nearest rank percentile of the sorted durations
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	rank := int(math.Ceil(p * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
 }
 
 func updateEvent(ctx context.Context, tx *Tx, id int, upd laundryNotify.EventUpdate) (*laundryNotify.Event, error) {
@@ -103,32 +349,67 @@ func updateEvent(ctx context.Context, tx *Tx, id int, upd laundryNotify.EventUpd
 	return event, nil
 }
 
-func createEvent(ctx context.Context, tx *Tx, event *laundryNotify.Event) error {
+// startEvent inserts the event only if no event of the type is running or started at the same time. The
+// check is part of the insert, so two starts racing each other can't both get in. Pending and standing
+// subscriptions are then attached to it. Starts from before the last cycle finished are rejected
+func startEvent(ctx context.Context, tx *Tx, eventType string, startedAt time.Time) (*laundryNotify.Event, []*laundryNotify.UserEvent, error) {
+	event := &laundryNotify.Event{
+		Type:      eventType,
+		StartedAt: sql.NullTime{Time: startedAt, Valid: true},
+	}
 	if err := event.Validate(); err != nil {
-		return err
+		return nil, nil, err
 	}
 
-	res, err := tx.ExecContext(
-		ctx,
-		`
-		INSERT INTO events (type, started_at, finished_at) 
-		VALUES (?, ?, ?)
+	// A start from before the last cycle finished arrived late, and belongs to a cycle that's already closed
+	if last, err := findMostRecentEvent(ctx, tx, eventType); err != nil {
+		return nil, nil, err
+	} else if last != nil && last.FinishedAt.Valid && startedAt.Before(last.FinishedAt.Time) {
+		return nil, nil, laundryNotify.Errorf(laundryNotify.EINVALID, "The %s started before its last cycle finished.", eventType)
+	}
+
+	res, err := tx.ExecContext(ctx, `
+		INSERT INTO events (type, started_at)
+		SELECT ?, ?
+		WHERE NOT EXISTS (
+			SELECT 1
+			FROM events
+			WHERE type = ?
+				AND (finished_at IS NULL OR started_at = ?)
+		)
 		`,
 		event.Type,
 		(*NullTime)(&event.StartedAt),
-		(*NullTime)(&event.FinishedAt),
+		event.Type,
+		(*NullTime)(&event.StartedAt),
 	)
 	if err != nil {
-		return err
+		return nil, nil, err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return nil, nil, err
+	} else if n == 0 {
+		return nil, nil, laundryNotify.Errorf(laundryNotify.ECONFLICT, "A %s event is already running or started at %s.", eventType, startedAt.Format(time.RFC3339))
 	}
 
 	id, err := res.LastInsertId()
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 	event.Id = int(id)
 
-	return nil
+	userEvents, err := attachUpcomingUserEvents(ctx, tx, event.Id)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Standing subscriptions go after the pending ones, so users that also registered aren't added twice
+	standing, err := materialiseStandingSubscriptions(ctx, tx, event)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return event, append(userEvents, standing...), nil
 }
 
 func findEventById(ctx context.Context, tx *Tx, id int) (*laundryNotify.Event, error) {
@@ -169,12 +450,24 @@ func findEvents(ctx context.Context, tx *Tx, filter laundryNotify.EventFilter) (
 	where, whereArgs := []string{"1 = 1"}, []interface{}{}
 	if v := filter.Id; v != nil {
 		where, whereArgs = append(where, "id = ?"), append(whereArgs, *v)
-	} else if v := filter.Type; v != nil {
+	}
+	if v := filter.Type; v != nil {
 		where, whereArgs = append(where, "type = ?"), append(whereArgs, *v)
-	} else if v := filter.StartedAt; !v.IsZero() {
-		where, whereArgs = append(where, "started_at = ?"), append(whereArgs, &v)
-	} else if v := filter.FinishedAt; !v.IsZero() {
-		where, whereArgs = append(where, "finished_at = ?"), append(whereArgs, &v)
+	}
+	if v := filter.StartedAfter; !v.IsZero() {
+		where, whereArgs = append(where, "started_at >= ?"), append(whereArgs, (*NullTime)(&sql.NullTime{Time: v, Valid: true}))
+	}
+	if v := filter.StartedBefore; !v.IsZero() {
+		where, whereArgs = append(where, "started_at < ?"), append(whereArgs, (*NullTime)(&sql.NullTime{Time: v, Valid: true}))
+	}
+	if v := filter.FinishedAfter; !v.IsZero() {
+		where, whereArgs = append(where, "finished_at >= ?"), append(whereArgs, (*NullTime)(&sql.NullTime{Time: v, Valid: true}))
+	}
+	if v := filter.FinishedBefore; !v.IsZero() {
+		where, whereArgs = append(where, "finished_at < ?"), append(whereArgs, (*NullTime)(&sql.NullTime{Time: v, Valid: true}))
+	}
+	if filter.Unfinished {
+		where = append(where, "finished_at IS NULL")
 	}
 
 	// Build ORDER BY clause
@@ -182,6 +475,9 @@ func findEvents(ctx context.Context, tx *Tx, filter laundryNotify.EventFilter) (
 	if filter.OrderBy != nil {
 		orderBy = filter.OrderBy
 	}
+	if err := ValidateOrderBy(orderBy, eventOrderByColumns); err != nil {
+		return nil, 0, err
+	}
 
 	rows, err := tx.QueryContext(ctx, `
 		SELECT 
@@ -189,6 +485,9 @@ func findEvents(ctx context.Context, tx *Tx, filter laundryNotify.EventFilter) (
 			type, 
 			started_at,
 			finished_at,
+			almost_done_at,
+			stale_at,
+			timed_out,
 			COUNT(*) OVER()
 		FROM events
 		WHERE `+strings.Join(where, " AND ")+`
@@ -209,6 +508,9 @@ func findEvents(ctx context.Context, tx *Tx, filter laundryNotify.EventFilter) (
 			&event.Type,
 			(*NullTime)(&event.StartedAt),
 			(*NullTime)(&event.FinishedAt),
+			(*NullTime)(&event.AlmostDoneAt),
+			(*NullTime)(&event.StaleAt),
+			&event.TimedOut,
 			&n,
 		); err != nil {
 			return nil, n, err
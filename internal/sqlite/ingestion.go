@@ -0,0 +1,240 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	laundryNotify "jallier/laundry-notify"
+	"strings"
+	"time"
+)
+
+// Ensure service implements interface.
+var _ laundryNotify.IngestionService = (*IngestionService)(nil)
+
+type IngestionService struct {
+	db *DB
+}
+
+func NewIngestionService(db *DB) *IngestionService {
+	return &IngestionService{db: db}
+}
+
+func (s *IngestionService) FindIngestions(ctx context.Context, filter laundryNotify.IngestionFilter) ([]*laundryNotify.Ingestion, int, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer tx.Rollback()
+	return findIngestions(ctx, tx, filter)
+}
+
+func (s *IngestionService) IngestStart(ctx context.Context, ingestion *laundryNotify.Ingestion) (*laundryNotify.Event, []*laundryNotify.UserEvent, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	var event *laundryNotify.Event
+	var userEvents []*laundryNotify.UserEvent
+	if err := ingest(ctx, tx, ingestion, laundryNotify.INGEST_LATE, func() (int, error) {
+		if event, userEvents, err = startEvent(ctx, tx, ingestion.Type, ingestion.At); err != nil {
+			return 0, err
+		}
+		return event.Id, nil
+	}); err != nil {
+		return nil, nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, nil, err
+	} else if ingestion.Decision != laundryNotify.INGEST_ACCEPTED {
+		return nil, nil, nil
+	}
+	return event, userEvents, nil
+}
+
+func (s *IngestionService) IngestFinish(ctx context.Context, ingestion *laundryNotify.Ingestion, notification laundryNotify.Notification, remindAfter []time.Duration) (*laundryNotify.Event, []*laundryNotify.Notification, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	var event *laundryNotify.Event
+	var notifications []*laundryNotify.Notification
+	if err := ingest(ctx, tx, ingestion, laundryNotify.INGEST_REJECTED, func() (int, error) {
+		if event, notifications, err = finishRunningEvent(ctx, tx, ingestion.Type, ingestion.At, notification, remindAfter); err != nil {
+			return 0, err
+		}
+		return event.Id, nil
+	}); err != nil {
+		return nil, nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, nil, err
+	} else if ingestion.Decision != laundryNotify.INGEST_ACCEPTED {
+		return nil, nil, nil
+	}
+	return event, notifications, nil
+}
+
+// ingest records the ingestion as a duplicate if a message with the same type, kind and timestamp was
+// already accepted, and otherwise applies it and records the result. apply returns the id of the event it
+// started or finished. It runs in a savepoint, so anything it did is undone if it fails. ECONFLICT and
+// ENOTFOUND errors are recorded as ignored and EINVALID errors with the invalid decision. Any other error is
+// returned without recording anything, and the caller rolls back the transaction
+func ingest(ctx context.Context, tx *Tx, ingestion *laundryNotify.Ingestion, invalid string, apply func() (int, error)) error {
+	accepted := laundryNotify.INGEST_ACCEPTED
+	previous, _, err := findIngestions(ctx, tx, laundryNotify.IngestionFilter{
+		Type:     &ingestion.Type,
+		Kind:     &ingestion.Kind,
+		At:       ingestion.At,
+		Decision: &accepted,
+		Limit:    1,
+	})
+	if err != nil {
+		return err
+	}
+
+	if len(previous) > 0 {
+		ingestion.Decision, ingestion.Reason = laundryNotify.INGEST_DUPLICATE, "A message with the same timestamp was already accepted."
+		ingestion.EventId = previous[0].EventId
+		return createIngestion(ctx, tx, ingestion)
+	}
+
+	if _, err := tx.ExecContext(ctx, `SAVEPOINT ingest`); err != nil {
+		return err
+	}
+	eventId, err := apply()
+	switch laundryNotify.ErrorCode(err) {
+	case "":
+		ingestion.Decision, ingestion.Reason, ingestion.EventId = laundryNotify.INGEST_ACCEPTED, "", eventId
+	case laundryNotify.ECONFLICT, laundryNotify.ENOTFOUND:
+		ingestion.Decision, ingestion.Reason = laundryNotify.INGEST_IGNORED, laundryNotify.ErrorMessage(err)
+	case laundryNotify.EINVALID:
+		ingestion.Decision, ingestion.Reason = invalid, laundryNotify.ErrorMessage(err)
+	default:
+		// Anything else, eg the database being busy, says nothing about the message, so nothing is recorded
+		// and the caller can try it again
+		return err
+	}
+	if err != nil {
+		if _, err := tx.ExecContext(ctx, `ROLLBACK TO ingest`); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.ExecContext(ctx, `RELEASE ingest`); err != nil {
+		return err
+	}
+
+	return createIngestion(ctx, tx, ingestion)
+}
+
+func createIngestion(ctx context.Context, tx *Tx, ingestion *laundryNotify.Ingestion) error {
+	ingestion.CreatedAt = sql.NullTime{Time: tx.now, Valid: true}
+
+	if err := ingestion.Validate(); err != nil {
+		return err
+	}
+
+	var eventId sql.NullInt64
+	if ingestion.EventId > 0 {
+		eventId = sql.NullInt64{Int64: int64(ingestion.EventId), Valid: true}
+	}
+	res, err := tx.ExecContext(ctx, `
+		INSERT INTO ingestion_log (type, kind, at, topic, payload, decision, reason, event_id, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		`,
+		ingestion.Type,
+		ingestion.Kind,
+		(*NullTime)(&sql.NullTime{Time: ingestion.At, Valid: true}),
+		ingestion.Topic,
+		ingestion.Payload,
+		ingestion.Decision,
+		ingestion.Reason,
+		eventId,
+		(*NullTime)(&ingestion.CreatedAt),
+	)
+	if err != nil {
+		return err
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+	ingestion.Id = int(id)
+
+	return nil
+}
+
+func findIngestions(ctx context.Context, tx *Tx, filter laundryNotify.IngestionFilter) (_ []*laundryNotify.Ingestion, n int, err error) {
+	// Build WHERE clause
+	where, args := []string{"1 = 1"}, []interface{}{}
+	if v := filter.Type; v != nil {
+		where, args = append(where, "type = ?"), append(args, *v)
+	}
+	if v := filter.Kind; v != nil {
+		where, args = append(where, "kind = ?"), append(args, *v)
+	}
+	if v := filter.At; !v.IsZero() {
+		where, args = append(where, "at = ?"), append(args, (*NullTime)(&sql.NullTime{Time: v, Valid: true}))
+	}
+	if v := filter.Decision; v != nil {
+		where, args = append(where, "decision = ?"), append(args, *v)
+	}
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT
+			id,
+			type,
+			kind,
+			at,
+			topic,
+			payload,
+			decision,
+			reason,
+			COALESCE(event_id, 0),
+			created_at,
+			COUNT(*) OVER()
+		FROM ingestion_log
+		WHERE `+strings.Join(where, " AND ")+`
+		ORDER BY id DESC
+		`+FormatLimitOffset(filter.Limit, filter.Offset),
+		args...,
+	)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	ingestions := make([]*laundryNotify.Ingestion, 0)
+	for rows.Next() {
+		var ingestion laundryNotify.Ingestion
+		var at sql.NullTime
+		if err := rows.Scan(
+			&ingestion.Id,
+			&ingestion.Type,
+			&ingestion.Kind,
+			(*NullTime)(&at),
+			&ingestion.Topic,
+			&ingestion.Payload,
+			&ingestion.Decision,
+			&ingestion.Reason,
+			&ingestion.EventId,
+			(*NullTime)(&ingestion.CreatedAt),
+			&n,
+		); err != nil {
+			return nil, 0, err
+		}
+		ingestion.At = at.Time
+		ingestions = append(ingestions, &ingestion)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	return ingestions, n, nil
+}
@@ -0,0 +1,112 @@
+package sqlite
+
+import (
+	"context"
+	"errors"
+	laundryNotify "jallier/laundry-notify"
+	"testing"
+	"time"
+)
+
+func TestIngestionService_Ingest(t *testing.T) {
+	ctx := context.Background()
+	db := MustOpenDB(t)
+	ingestions := NewIngestionService(db)
+
+	startedAt := time.Now().UTC().Truncate(time.Second).Add(-time.Hour)
+	start := func() *laundryNotify.Ingestion {
+		return &laundryNotify.Ingestion{Type: "washer", Kind: "started_at", At: startedAt}
+	}
+	finish := func(at time.Time) *laundryNotify.Ingestion {
+		return &laundryNotify.Ingestion{Type: "washer", Kind: "finished_at", At: at}
+	}
+
+	first := start()
+	event, _, err := ingestions.IngestStart(ctx, first)
+	if err != nil {
+		t.Fatal(err)
+	} else if first.Decision != laundryNotify.INGEST_ACCEPTED || event == nil || first.EventId != event.Id {
+		t.Fatalf("decision=%s event=%v, want accepted", first.Decision, event)
+	}
+
+	// The same start again, eg a retained message seen on reconnect, doesn't start anything
+	again := start()
+	if event, _, err := ingestions.IngestStart(ctx, again); err != nil {
+		t.Fatal(err)
+	} else if again.Decision != laundryNotify.INGEST_DUPLICATE || event != nil || again.EventId != first.EventId {
+		t.Fatalf("decision=%s event=%v event id=%d, want duplicate of %d", again.Decision, event, again.EventId, first.EventId)
+	}
+
+	// A finish before the start is rejected, and leaves the event running
+	early := finish(startedAt.Add(-time.Minute))
+	if event, _, err := ingestions.IngestFinish(ctx, early, laundryNotify.Notification{Title: "Done"}, nil); err != nil {
+		t.Fatal(err)
+	} else if early.Decision != laundryNotify.INGEST_REJECTED || event != nil {
+		t.Fatalf("decision=%s event=%v, want rejected", early.Decision, event)
+	}
+
+	done := finish(startedAt.Add(time.Hour))
+	if event, _, err := ingestions.IngestFinish(ctx, done, laundryNotify.Notification{Title: "Done"}, nil); err != nil {
+		t.Fatal(err)
+	} else if done.Decision != laundryNotify.INGEST_ACCEPTED || event == nil || !event.FinishedAt.Valid {
+		t.Fatalf("decision=%s event=%v, want accepted", done.Decision, event)
+	}
+
+	// A start from before the last cycle finished arrived late, and doesn't start a new cycle
+	late := &laundryNotify.Ingestion{Type: "washer", Kind: "started_at", At: startedAt.Add(30 * time.Minute)}
+	if event, _, err := ingestions.IngestStart(ctx, late); err != nil {
+		t.Fatal(err)
+	} else if late.Decision != laundryNotify.INGEST_LATE || event != nil || late.EventId != 0 {
+		t.Fatalf("decision=%s event=%v, want late", late.Decision, event)
+	}
+
+	accepted := laundryNotify.INGEST_ACCEPTED
+	if _, n, err := ingestions.FindIngestions(ctx, laundryNotify.IngestionFilter{Decision: &accepted}); err != nil {
+		t.Fatal(err)
+	} else if n != 2 {
+		t.Fatalf("accepted=%d, want 2", n)
+	} else if _, n, err := ingestions.FindIngestions(ctx, laundryNotify.IngestionFilter{}); err != nil {
+		t.Fatal(err)
+	} else if n != 5 {
+		t.Fatalf("ingestions=%d, want 5", n)
+	}
+
+	// The unique index stops the same message being accepted twice, even without the lookup
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	duplicate := start()
+	duplicate.Decision = laundryNotify.INGEST_ACCEPTED
+	if err := createIngestion(ctx, tx, duplicate); err == nil {
+		t.Fatal("expected accepting the same message twice to fail")
+	}
+}
+
+func TestIngest_UnexpectedError(t *testing.T) {
+	ctx := context.Background()
+	db := MustOpenDB(t)
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	// Errors that aren't about the message, eg the database being busy, are returned rather than recorded,
+	// so the message can be tried again
+	busy := errors.New("database is locked")
+	ingestion := &laundryNotify.Ingestion{Type: "washer", Kind: "started_at", At: time.Now().UTC().Truncate(time.Second)}
+	if err := ingest(ctx, tx, ingestion, laundryNotify.INGEST_LATE, func() (int, error) {
+		return 0, busy
+	}); err != busy {
+		t.Fatalf("err=%v, want %v", err, busy)
+	}
+
+	if _, n, err := findIngestions(ctx, tx, laundryNotify.IngestionFilter{}); err != nil {
+		t.Fatal(err)
+	} else if n != 0 {
+		t.Fatalf("ingestions=%d, want 0", n)
+	}
+}
@@ -0,0 +1,18 @@
+create table
+  if not exists notifications (
+    id integer not null primary key,
+    user_id integer,
+    event_id integer,
+    topic text not null default '',
+    title text not null,
+    message text not null,
+    status text not null default 'pending',
+    attempts integer not null default 0,
+    last_error text not null default '',
+    next_attempt_at datetime not null,
+    created_at datetime not null,
+    delivered_at datetime
+  );
+
+create index
+  if not exists notifications_status_next_attempt_at on notifications (status, next_attempt_at);
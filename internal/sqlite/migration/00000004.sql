@@ -0,0 +1,17 @@
+create table
+  if not exists reminders (
+    id integer not null primary key,
+    user_id integer not null,
+    event_id integer not null,
+    type text not null,
+    remind_at datetime not null,
+    created_at datetime not null,
+    sent_at datetime,
+    cancelled_at datetime
+  );
+
+create index
+  if not exists reminders_remind_at on reminders (remind_at)
+  where
+    sent_at is null
+    and cancelled_at is null;
@@ -0,0 +1,2 @@
+ALTER TABLE events
+  ADD COLUMN almost_done_at datetime;
@@ -0,0 +1,11 @@
+create table
+  if not exists user_preferences (
+    user_id integer not null primary key,
+    channel text not null default '',
+    target text not null default '',
+    quiet_start text not null default '',
+    quiet_end text not null default '',
+    priority text not null default '',
+    language text not null default '',
+    updated_at datetime not null
+  );
@@ -0,0 +1,12 @@
+ALTER TABLE users
+  ADD COLUMN pin_hash text;
+
+create table
+  if not exists login_tokens (
+    id integer not null primary key,
+    user_id integer not null,
+    token_hash text not null unique,
+    expires_at datetime not null,
+    created_at datetime not null,
+    used_at datetime
+  );
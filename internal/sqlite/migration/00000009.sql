@@ -0,0 +1,2 @@
+ALTER TABLE events
+  ADD COLUMN timed_out integer not null default 0;
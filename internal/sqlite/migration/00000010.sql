@@ -0,0 +1,5 @@
+ALTER TABLE user_events
+  ADD COLUMN follow_on text not null default '';
+
+ALTER TABLE user_events
+  ADD COLUMN chained_from_id integer;
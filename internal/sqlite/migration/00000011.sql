@@ -0,0 +1,16 @@
+create table
+  if not exists standing_subscriptions (
+    id integer not null primary key,
+    user_id integer not null,
+    type text not null,
+    schedule text not null,
+    weekdays text not null default '',
+    window_start text not null default '',
+    window_end text not null default '',
+    remaining integer not null default 0,
+    expires_at datetime not null,
+    created_at datetime not null
+  );
+
+create index
+  if not exists standing_subscriptions_type_expires_at on standing_subscriptions (type, expires_at);
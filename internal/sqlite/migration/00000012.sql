@@ -0,0 +1,16 @@
+create table
+  if not exists ingestion_log (
+    id integer not null primary key,
+    type text not null,
+    kind text not null,
+    at datetime not null,
+    topic text not null default '',
+    payload text not null default '',
+    decision text not null,
+    reason text not null default '',
+    event_id integer,
+    created_at datetime not null
+  );
+
+create index
+  if not exists ingestion_log_type_kind_at on ingestion_log (type, kind, at);
@@ -0,0 +1,2 @@
+ALTER TABLE users
+  ADD COLUMN credential_version integer not null default 0;
@@ -0,0 +1,15 @@
+-- Only one message per appliance, kind and timestamp can be accepted. Any accepted more than once before
+-- this index existed are marked as duplicates first
+UPDATE ingestion_log
+SET decision = 'duplicate'
+WHERE decision = 'accepted'
+  AND id NOT IN (
+    SELECT MIN(id)
+    FROM ingestion_log
+    WHERE decision = 'accepted'
+    GROUP BY type, kind, at
+  );
+
+create unique index
+  if not exists ingestion_log_accepted on ingestion_log (type, kind, at)
+  where decision = 'accepted';
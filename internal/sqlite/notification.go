@@ -0,0 +1,252 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	laundryNotify "jallier/laundry-notify"
+	"strings"
+)
+
+// Ensure service implements interface.
+var _ laundryNotify.NotificationService = (*NotificationService)(nil)
+
+// NotificationService represents the outbox of notifications waiting to be delivered.
+type NotificationService struct {
+	db *DB
+}
+
+func NewNotificationService(db *DB) *NotificationService {
+	return &NotificationService{db: db}
+}
+
+func (s *NotificationService) FindNotifications(ctx context.Context, filter laundryNotify.NotificationFilter) ([]*laundryNotify.Notification, int, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer tx.Rollback()
+	return findNotifications(ctx, tx, filter)
+}
+
+func (s *NotificationService) CreateNotification(ctx context.Context, notification *laundryNotify.Notification) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := createNotification(ctx, tx, notification); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *NotificationService) UpdateNotification(ctx context.Context, id int, update laundryNotify.NotificationUpdate) (*laundryNotify.Notification, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	notification, err := updateNotification(ctx, tx, id, update)
+	if err != nil {
+		return nil, err
+	}
+
+	return notification, tx.Commit()
+}
+
+// createNotification queues a notification. It is due immediately unless NextAttemptAt is set
+func createNotification(ctx context.Context, tx *Tx, notification *laundryNotify.Notification) error {
+	notification.CreatedAt = sql.NullTime{Time: tx.now, Valid: true}
+	if notification.Status == "" {
+		notification.Status = laundryNotify.NOTIFICATION_PENDING
+	}
+	if !notification.NextAttemptAt.Valid {
+		notification.NextAttemptAt = notification.CreatedAt
+	}
+
+	if err := notification.Validate(); err != nil {
+		return err
+	}
+
+	res, err := tx.ExecContext(ctx, `
+		INSERT INTO notifications (
+			user_id,
+			event_id,
+			topic,
+			title,
+			message,
+			status,
+			attempts,
+			last_error,
+			next_attempt_at,
+			created_at,
+			delivered_at
+		)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		`,
+		nullInt(notification.UserId),
+		nullInt(notification.EventId),
+		notification.Topic,
+		notification.Title,
+		notification.Message,
+		notification.Status,
+		notification.Attempts,
+		notification.LastError,
+		(*NullTime)(&notification.NextAttemptAt),
+		(*NullTime)(&notification.CreatedAt),
+		(*NullTime)(&notification.DeliveredAt),
+	)
+	if err != nil {
+		return err
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+	notification.Id = int(id)
+
+	return nil
+}
+
+func findNotificationById(ctx context.Context, tx *Tx, id int) (*laundryNotify.Notification, error) {
+	a, _, err := findNotifications(ctx, tx, laundryNotify.NotificationFilter{Id: &id})
+	if err != nil {
+		return nil, err
+	}
+	if len(a) == 0 {
+		return nil, laundryNotify.Errorf(laundryNotify.ENOTFOUND, "Notification not found: %d", id)
+	}
+	return a[0], nil
+}
+
+func findNotifications(ctx context.Context, tx *Tx, filter laundryNotify.NotificationFilter) (_ []*laundryNotify.Notification, n int, err error) {
+	// Build WHERE clause
+	where, args := []string{"1 = 1"}, []interface{}{}
+	if v := filter.Id; v != nil {
+		where, args = append(where, "id = ?"), append(args, *v)
+	}
+	if v := filter.UserId; v != nil {
+		where, args = append(where, "user_id = ?"), append(args, *v)
+	}
+	if v := filter.EventId; v != nil {
+		where, args = append(where, "event_id = ?"), append(args, *v)
+	}
+	if v := filter.Status; v != nil {
+		where, args = append(where, "status = ?"), append(args, *v)
+	}
+	if v := filter.DueBy; !v.IsZero() {
+		where, args = append(where, "next_attempt_at <= ?"), append(args, (*NullTime)(&sql.NullTime{Time: v, Valid: true}))
+	}
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT
+			id,
+			COALESCE(user_id, 0),
+			COALESCE(event_id, 0),
+			topic,
+			title,
+			message,
+			status,
+			attempts,
+			last_error,
+			next_attempt_at,
+			created_at,
+			delivered_at,
+			COUNT(*) OVER()
+		FROM notifications
+		WHERE `+strings.Join(where, " AND ")+`
+		ORDER BY next_attempt_at, id
+		`+FormatLimitOffset(filter.Limit, filter.Offset),
+		args...,
+	)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	notifications := make([]*laundryNotify.Notification, 0)
+	for rows.Next() {
+		var notification laundryNotify.Notification
+		if err := rows.Scan(
+			&notification.Id,
+			&notification.UserId,
+			&notification.EventId,
+			&notification.Topic,
+			&notification.Title,
+			&notification.Message,
+			&notification.Status,
+			&notification.Attempts,
+			&notification.LastError,
+			(*NullTime)(&notification.NextAttemptAt),
+			(*NullTime)(&notification.CreatedAt),
+			(*NullTime)(&notification.DeliveredAt),
+			&n,
+		); err != nil {
+			return nil, 0, err
+		}
+		notifications = append(notifications, &notification)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	return notifications, n, nil
+}
+
+func updateNotification(ctx context.Context, tx *Tx, id int, update laundryNotify.NotificationUpdate) (*laundryNotify.Notification, error) {
+	notification, err := findNotificationById(ctx, tx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if v := update.Status; v != nil {
+		notification.Status = *v
+	}
+	if v := update.Attempts; v != nil {
+		notification.Attempts = *v
+	}
+	if v := update.LastError; v != nil {
+		notification.LastError = *v
+	}
+	if v := update.NextAttemptAt; v.Valid {
+		notification.NextAttemptAt = v
+	}
+	if v := update.DeliveredAt; v.Valid {
+		notification.DeliveredAt = v
+	}
+
+	if err := notification.Validate(); err != nil {
+		return nil, err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE notifications
+		SET status = ?,
+			attempts = ?,
+			last_error = ?,
+			next_attempt_at = ?,
+			delivered_at = ?
+		WHERE id = ?
+		`,
+		notification.Status,
+		notification.Attempts,
+		notification.LastError,
+		(*NullTime)(&notification.NextAttemptAt),
+		(*NullTime)(&notification.DeliveredAt),
+		id,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return notification, nil
+}
+
+// nullInt stores zero ids as NULL
+func nullInt(v int) sql.NullInt64 {
+	return sql.NullInt64{Int64: int64(v), Valid: v != 0}
+}
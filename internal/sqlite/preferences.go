@@ -0,0 +1,114 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	laundryNotify "jallier/laundry-notify"
+)
+
+// Ensure service implements interface.
+var _ laundryNotify.PreferencesService = (*PreferencesService)(nil)
+
+type PreferencesService struct {
+	db *DB
+}
+
+func NewPreferencesService(db *DB) *PreferencesService {
+	return &PreferencesService{db: db}
+}
+
+func (s *PreferencesService) FindPreferences(ctx context.Context, userId int) (*laundryNotify.Preferences, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	return findPreferences(ctx, tx, userId)
+}
+
+func (s *PreferencesService) SavePreferences(ctx context.Context, preferences *laundryNotify.Preferences) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := savePreferences(ctx, tx, preferences); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func findPreferences(ctx context.Context, tx *Tx, userId int) (*laundryNotify.Preferences, error) {
+	if user, err := findUserById(ctx, tx, userId); err != nil {
+		return nil, err
+	} else if user == nil {
+		return nil, laundryNotify.Errorf(laundryNotify.ENOTFOUND, "User not found: %d", userId)
+	}
+
+	preferences := laundryNotify.Preferences{UserId: userId}
+	err := tx.QueryRowContext(ctx, `
+		SELECT
+			channel,
+			target,
+			quiet_start,
+			quiet_end,
+			priority,
+			language,
+			updated_at
+		FROM user_preferences
+		WHERE user_id = ?
+		`,
+		userId,
+	).Scan(
+		&preferences.Channel,
+		&preferences.Target,
+		&preferences.QuietStart,
+		&preferences.QuietEnd,
+		&preferences.Priority,
+		&preferences.Language,
+		(*NullTime)(&preferences.UpdatedAt),
+	)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	return &preferences, nil
+}
+
+func savePreferences(ctx context.Context, tx *Tx, preferences *laundryNotify.Preferences) error {
+	if user, err := findUserById(ctx, tx, preferences.UserId); err != nil {
+		return err
+	} else if user == nil {
+		return laundryNotify.Errorf(laundryNotify.ENOTFOUND, "User not found: %d", preferences.UserId)
+	}
+
+	preferences.UpdatedAt = sql.NullTime{Time: tx.now, Valid: true}
+	if err := preferences.Validate(); err != nil {
+		return err
+	}
+
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO user_preferences (user_id, channel, target, quiet_start, quiet_end, priority, language, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (user_id) DO UPDATE SET
+			channel = excluded.channel,
+			target = excluded.target,
+			quiet_start = excluded.quiet_start,
+			quiet_end = excluded.quiet_end,
+			priority = excluded.priority,
+			language = excluded.language,
+			updated_at = excluded.updated_at
+		`,
+		preferences.UserId,
+		preferences.Channel,
+		preferences.Target,
+		preferences.QuietStart,
+		preferences.QuietEnd,
+		preferences.Priority,
+		preferences.Language,
+		(*NullTime)(&preferences.UpdatedAt),
+	)
+	return err
+}
@@ -0,0 +1,252 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	laundryNotify "jallier/laundry-notify"
+	"strings"
+	"time"
+)
+
+// Ensure service implements interface.
+var _ laundryNotify.ReminderService = (*ReminderService)(nil)
+
+type ReminderService struct {
+	db *DB
+}
+
+func NewReminderService(db *DB) *ReminderService {
+	return &ReminderService{db: db}
+}
+
+func (s *ReminderService) FindReminders(ctx context.Context, filter laundryNotify.ReminderFilter) ([]*laundryNotify.Reminder, int, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer tx.Rollback()
+	return findReminders(ctx, tx, filter)
+}
+
+func (s *ReminderService) CreateReminders(ctx context.Context, reminders []*laundryNotify.Reminder) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, reminder := range reminders {
+		if err := createReminder(ctx, tx, reminder); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (s *ReminderService) SendReminder(ctx context.Context, id int, notification laundryNotify.Notification) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	reminder, err := findReminderById(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+	if reminder.SentAt.Valid || reminder.CancelledAt.Valid {
+		return laundryNotify.Errorf(laundryNotify.ECONFLICT, "Reminder is no longer pending: %d", id)
+	}
+
+	notification.UserId, notification.EventId = reminder.UserId, reminder.EventId
+	if err := createNotification(ctx, tx, &notification); err != nil {
+		return err
+	}
+
+	sentAt := sql.NullTime{Time: tx.now, Valid: true}
+	if _, err := tx.ExecContext(ctx, `UPDATE reminders SET sent_at = ? WHERE id = ?`, (*NullTime)(&sentAt), id); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *ReminderService) CancelReminder(ctx context.Context, id int) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := findReminderById(ctx, tx, id); err != nil {
+		return err
+	}
+
+	cancelledAt := sql.NullTime{Time: tx.now, Valid: true}
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE reminders
+		SET cancelled_at = ?
+		WHERE id = ? AND sent_at IS NULL AND cancelled_at IS NULL
+		`,
+		(*NullTime)(&cancelledAt),
+		id,
+	); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *ReminderService) AcknowledgeReminders(ctx context.Context, userId int, eventId int) (int, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	cancelledAt := sql.NullTime{Time: tx.now, Valid: true}
+	res, err := tx.ExecContext(ctx, `
+		UPDATE reminders
+		SET cancelled_at = ?
+		WHERE user_id = ? AND event_id = ? AND sent_at IS NULL AND cancelled_at IS NULL
+		`,
+		(*NullTime)(&cancelledAt),
+		userId,
+		eventId,
+	)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(n), tx.Commit()
+}
+
+func createReminder(ctx context.Context, tx *Tx, reminder *laundryNotify.Reminder) error {
+	reminder.CreatedAt = sql.NullTime{Time: tx.now, Valid: true}
+
+	if err := reminder.Validate(); err != nil {
+		return err
+	}
+
+	res, err := tx.ExecContext(ctx, `
+		INSERT INTO reminders (user_id, event_id, type, remind_at, created_at)
+		VALUES (?, ?, ?, ?, ?)
+		`,
+		reminder.UserId,
+		reminder.EventId,
+		reminder.Type,
+		(*NullTime)(&reminder.RemindAt),
+		(*NullTime)(&reminder.CreatedAt),
+	)
+	if err != nil {
+		return err
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+	reminder.Id = int(id)
+
+	return nil
+}
+
+// createEventReminders schedules a reminder after each of the durations for each user notified that the
+// event finished
+func createEventReminders(ctx context.Context, tx *Tx, event *laundryNotify.Event, notifications []*laundryNotify.Notification, remindAfter []time.Duration) error {
+	for _, notification := range notifications {
+		for _, after := range remindAfter {
+			if err := createReminder(ctx, tx, &laundryNotify.Reminder{
+				UserId:   notification.UserId,
+				EventId:  event.Id,
+				Type:     event.Type,
+				RemindAt: sql.NullTime{Time: event.FinishedAt.Time.Add(after), Valid: true},
+			}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func findReminderById(ctx context.Context, tx *Tx, id int) (*laundryNotify.Reminder, error) {
+	a, _, err := findReminders(ctx, tx, laundryNotify.ReminderFilter{Id: &id})
+	if err != nil {
+		return nil, err
+	}
+	if len(a) == 0 {
+		return nil, laundryNotify.Errorf(laundryNotify.ENOTFOUND, "Reminder not found: %d", id)
+	}
+	return a[0], nil
+}
+
+func findReminders(ctx context.Context, tx *Tx, filter laundryNotify.ReminderFilter) (_ []*laundryNotify.Reminder, n int, err error) {
+	// Build WHERE clause
+	where, args := []string{"1 = 1"}, []interface{}{}
+	if v := filter.Id; v != nil {
+		where, args = append(where, "id = ?"), append(args, *v)
+	}
+	if v := filter.UserId; v != nil {
+		where, args = append(where, "user_id = ?"), append(args, *v)
+	}
+	if v := filter.EventId; v != nil {
+		where, args = append(where, "event_id = ?"), append(args, *v)
+	}
+	if filter.Pending {
+		where = append(where, "sent_at IS NULL", "cancelled_at IS NULL")
+	}
+	if v := filter.DueBy; !v.IsZero() {
+		where, args = append(where, "remind_at <= ?"), append(args, (*NullTime)(&sql.NullTime{Time: v, Valid: true}))
+	}
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT
+			id,
+			user_id,
+			event_id,
+			type,
+			remind_at,
+			sent_at,
+			cancelled_at,
+			created_at,
+			COUNT(*) OVER()
+		FROM reminders
+		WHERE `+strings.Join(where, " AND ")+`
+		ORDER BY remind_at, id
+		`+FormatLimitOffset(filter.Limit, filter.Offset),
+		args...,
+	)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	reminders := make([]*laundryNotify.Reminder, 0)
+	for rows.Next() {
+		var reminder laundryNotify.Reminder
+		if err := rows.Scan(
+			&reminder.Id,
+			&reminder.UserId,
+			&reminder.EventId,
+			&reminder.Type,
+			(*NullTime)(&reminder.RemindAt),
+			(*NullTime)(&reminder.SentAt),
+			(*NullTime)(&reminder.CancelledAt),
+			(*NullTime)(&reminder.CreatedAt),
+			&n,
+		); err != nil {
+			return nil, 0, err
+		}
+		reminders = append(reminders, &reminder)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	return reminders, n, nil
+}
@@ -7,6 +7,7 @@ import (
 	"embed"
 	"fmt"
 	"io/fs"
+	laundryNotify "jallier/laundry-notify"
 	"os"
 	"path/filepath"
 	"slices"
@@ -234,6 +235,21 @@ func FormatLimitOffset(limit, offset int) string {
 	return ""
 }
 
+// ValidateOrderBy checks each ORDER BY term is one of the allowed columns, optionally followed by ASC
+// or DESC, as they are added to the query as is.
+func ValidateOrderBy(orderBy []string, columns []string) error {
+	for _, term := range orderBy {
+		fields := strings.Fields(term)
+		if len(fields) == 0 || len(fields) > 2 || !slices.Contains(columns, fields[0]) {
+			return laundryNotify.Errorf(laundryNotify.EINVALID, "Invalid order by: %s", term)
+		}
+		if len(fields) == 2 && !strings.EqualFold(fields[1], "ASC") && !strings.EqualFold(fields[1], "DESC") {
+			return laundryNotify.Errorf(laundryNotify.EINVALID, "Invalid order by direction: %s", term)
+		}
+	}
+	return nil
+}
+
 func FormatOrderBy(orderBy []string) string {
 	if len(orderBy) == 0 {
 		return ""
@@ -0,0 +1,45 @@
+package sqlite
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// MustOpenDB returns a new, open in-memory DB. Fatal on error.
+func MustOpenDB(tb testing.TB) *DB {
+	tb.Helper()
+
+	db := NewDB(":memory:")
+	if err := db.Open(); err != nil {
+		tb.Fatal(err)
+	}
+
+	// Each connection to :memory: gets its own database, so share one between goroutines
+	db.db.SetMaxOpenConns(1)
+
+	tb.Cleanup(func() {
+		if err := db.Close(); err != nil {
+			tb.Fatal(err)
+		}
+	})
+	return db
+}
+
+// MustOpenFileDB returns a new, open DB in a temporary file, which can have several connections at once
+// unlike an in-memory DB. Transactions take the write lock when they begin, waiting for it if another
+// connection holds it, so concurrent transactions queue up rather than fail. Fatal on error.
+func MustOpenFileDB(tb testing.TB) *DB {
+	tb.Helper()
+
+	db := NewDB(filepath.Join(tb.TempDir(), "db") + "?_busy_timeout=5000&_txlock=immediate")
+	if err := db.Open(); err != nil {
+		tb.Fatal(err)
+	}
+
+	tb.Cleanup(func() {
+		if err := db.Close(); err != nil {
+			tb.Fatal(err)
+		}
+	})
+	return db
+}
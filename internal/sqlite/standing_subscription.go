@@ -0,0 +1,262 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	laundryNotify "jallier/laundry-notify"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Ensure service implements interface.
+var _ laundryNotify.StandingSubscriptionService = (*StandingSubscriptionService)(nil)
+
+type StandingSubscriptionService struct {
+	db *DB
+}
+
+func NewStandingSubscriptionService(db *DB) *StandingSubscriptionService {
+	return &StandingSubscriptionService{db: db}
+}
+
+func (s *StandingSubscriptionService) FindStandingSubscriptions(ctx context.Context, filter laundryNotify.StandingSubscriptionFilter) ([]*laundryNotify.StandingSubscription, int, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer tx.Rollback()
+	return findStandingSubscriptions(ctx, tx, filter)
+}
+
+func (s *StandingSubscriptionService) CreateStandingSubscription(ctx context.Context, subscription *laundryNotify.StandingSubscription) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := createStandingSubscription(ctx, tx, subscription); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *StandingSubscriptionService) DeleteStandingSubscription(ctx context.Context, id int) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := deleteStandingSubscription(ctx, tx, id); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func createStandingSubscription(ctx context.Context, tx *Tx, subscription *laundryNotify.StandingSubscription) error {
+	if user, err := findUserById(ctx, tx, subscription.UserId); err != nil {
+		return err
+	} else if user == nil {
+		return laundryNotify.Errorf(laundryNotify.ENOTFOUND, "User not found: %d", subscription.UserId)
+	}
+
+	subscription.CreatedAt = sql.NullTime{Time: tx.now, Valid: true}
+	if err := subscription.Validate(); err != nil {
+		return err
+	}
+	if !subscription.ExpiresAt.Time.After(tx.now) {
+		return laundryNotify.Errorf(laundryNotify.EINVALID, "Standing subscription expiry must be in the future.")
+	}
+
+	res, err := tx.ExecContext(ctx, `
+		INSERT INTO standing_subscriptions (user_id, type, schedule, weekdays, window_start, window_end, remaining, expires_at, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		`,
+		subscription.UserId,
+		subscription.Type,
+		subscription.Schedule,
+		formatWeekdays(subscription.Weekdays),
+		subscription.WindowStart,
+		subscription.WindowEnd,
+		subscription.Remaining,
+		(*NullTime)(&subscription.ExpiresAt),
+		(*NullTime)(&subscription.CreatedAt),
+	)
+	if err != nil {
+		return err
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+	subscription.Id = int(id)
+
+	return nil
+}
+
+func deleteStandingSubscription(ctx context.Context, tx *Tx, id int) error {
+	if _, err := findStandingSubscriptionById(ctx, tx, id); err != nil {
+		return err
+	}
+
+	_, err := tx.ExecContext(ctx, `DELETE FROM standing_subscriptions WHERE id = ?`, id)
+	return err
+}
+
+// materialiseStandingSubscriptions creates a user event for the event for each standing subscription that
+// matches it, skipping users that are already subscribed to it
+func materialiseStandingSubscriptions(ctx context.Context, tx *Tx, event *laundryNotify.Event) ([]*laundryNotify.UserEvent, error) {
+	subscriptions, _, err := findStandingSubscriptions(ctx, tx, laundryNotify.StandingSubscriptionFilter{
+		Type:   &event.Type,
+		Active: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	userEvents := make([]*laundryNotify.UserEvent, 0)
+	for _, subscription := range subscriptions {
+		if !subscription.Matches(event.StartedAt.Time) {
+			continue
+		}
+
+		if _, n, err := findUserEvents(ctx, tx, laundryNotify.UserEventFilter{
+			UserId:  &subscription.UserId,
+			EventId: &event.Id,
+			Limit:   1,
+		}); err != nil {
+			return nil, err
+		} else if n > 0 {
+			continue
+		}
+
+		userEvent := &laundryNotify.UserEvent{
+			UserId:  subscription.UserId,
+			EventId: event.Id,
+			Type:    event.Type,
+		}
+		if err := createUserEvent(ctx, tx, userEvent); err != nil {
+			return nil, err
+		}
+		userEvents = append(userEvents, userEvent)
+
+		if subscription.Schedule == laundryNotify.SCHEDULE_COUNT {
+			if _, err := tx.ExecContext(ctx, `
+				UPDATE standing_subscriptions
+				SET remaining = remaining - 1
+				WHERE id = ?
+				`,
+				subscription.Id,
+			); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return userEvents, nil
+}
+
+func findStandingSubscriptionById(ctx context.Context, tx *Tx, id int) (*laundryNotify.StandingSubscription, error) {
+	a, _, err := findStandingSubscriptions(ctx, tx, laundryNotify.StandingSubscriptionFilter{Id: &id})
+	if err != nil {
+		return nil, err
+	}
+	if len(a) == 0 {
+		return nil, laundryNotify.Errorf(laundryNotify.ENOTFOUND, "Standing subscription not found: %d", id)
+	}
+	return a[0], nil
+}
+
+func findStandingSubscriptions(ctx context.Context, tx *Tx, filter laundryNotify.StandingSubscriptionFilter) (_ []*laundryNotify.StandingSubscription, n int, err error) {
+	// Build WHERE clause
+	where, args := []string{"1 = 1"}, []interface{}{}
+	if v := filter.Id; v != nil {
+		where, args = append(where, "id = ?"), append(args, *v)
+	}
+	if v := filter.UserId; v != nil {
+		where, args = append(where, "user_id = ?"), append(args, *v)
+	}
+	if v := filter.Type; v != nil {
+		where, args = append(where, "type = ?"), append(args, *v)
+	}
+	if filter.Active {
+		where, args = append(where, "expires_at > ?", "(schedule != ? OR remaining > 0)"),
+			append(args, (*NullTime)(&sql.NullTime{Time: tx.now, Valid: true}), laundryNotify.SCHEDULE_COUNT)
+	}
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT
+			id,
+			user_id,
+			type,
+			schedule,
+			weekdays,
+			window_start,
+			window_end,
+			remaining,
+			expires_at,
+			created_at,
+			COUNT(*) OVER()
+		FROM standing_subscriptions
+		WHERE `+strings.Join(where, " AND ")+`
+		ORDER BY created_at, id
+		`+FormatLimitOffset(filter.Limit, filter.Offset),
+		args...,
+	)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	subscriptions := make([]*laundryNotify.StandingSubscription, 0)
+	for rows.Next() {
+		var subscription laundryNotify.StandingSubscription
+		var weekdays string
+		if err := rows.Scan(
+			&subscription.Id,
+			&subscription.UserId,
+			&subscription.Type,
+			&subscription.Schedule,
+			&weekdays,
+			&subscription.WindowStart,
+			&subscription.WindowEnd,
+			&subscription.Remaining,
+			(*NullTime)(&subscription.ExpiresAt),
+			(*NullTime)(&subscription.CreatedAt),
+			&n,
+		); err != nil {
+			return nil, 0, err
+		}
+		subscription.Weekdays = parseWeekdays(weekdays)
+		subscriptions = append(subscriptions, &subscription)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	return subscriptions, n, nil
+}
+
+// Weekdays are stored as a comma separated list of day numbers, with Sunday as 0
+func formatWeekdays(weekdays []time.Weekday) string {
+	a := make([]string, len(weekdays))
+	for i, day := range weekdays {
+		a[i] = strconv.Itoa(int(day))
+	}
+	return strings.Join(a, ",")
+}
+
+func parseWeekdays(s string) []time.Weekday {
+	weekdays := make([]time.Weekday, 0)
+	for _, v := range strings.Split(s, ",") {
+		if day, err := strconv.Atoi(v); err == nil {
+			weekdays = append(weekdays, time.Weekday(day))
+		}
+	}
+	return weekdays
+}
@@ -148,6 +148,7 @@ func findUsers(ctx context.Context, tx *Tx, filter laundryNotify.UserFilter) (_
 			id, 
 			name, 
 			created_at,
+			pin_hash IS NOT NULL,
 			COUNT(*) OVER()
 		FROM users 
 		WHERE `+strings.Join(where, " AND ")+`
@@ -167,6 +168,7 @@ func findUsers(ctx context.Context, tx *Tx, filter laundryNotify.UserFilter) (_
 			&user.Id,
 			&user.Name,
 			(*NullTime)(&user.CreatedAt),
+			&user.Protected,
 			&n,
 		); err != nil {
 			return nil, n, err
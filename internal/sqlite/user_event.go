@@ -44,63 +44,77 @@ func (s *UserEventService) FindUserNamesByEventId(ctx context.Context, eventId i
 	return events, nil
 }
 
-func (s *UserEventService) FindByUserName(ctx context.Context, name string, eventType string) ([]*laundryNotify.UserEvent, int, error) {
+func (s *UserEventService) CreateUserEvent(ctx context.Context, userEvent *laundryNotify.UserEvent) error {
 	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
-		return nil, 0, err
+		return err
 	}
 	defer tx.Rollback()
 
-	events, n, err := findByUserName(ctx, tx, name, eventType)
+	if err := createUserEvent(ctx, tx, userEvent); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Subscribe registers the user for the running event of the type, or the next one if none is running. If
+// they're already registered, userEvent is set to the existing user event, with its follow on updated.
+// Returns the running event, nil if they're waiting for the next one, and whether they were already
+// registered
+func (s *UserEventService) Subscribe(ctx context.Context, userEvent *laundryNotify.UserEvent) (*laundryNotify.Event, bool, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
-		return nil, 0, err
+		return nil, false, err
+	}
+	defer tx.Rollback()
+
+	event, existing, err := subscribe(ctx, tx, userEvent)
+	if err != nil {
+		return nil, false, err
 	}
 
-	return events, n, nil
+	return event, existing, tx.Commit()
 }
 
-func (s *UserEventService) FindUpcomingUserEvents(ctx context.Context, eventType string) ([]*laundryNotify.UserEvent, int, error) {
+func (s *UserEventService) UpdateUserEvent(ctx context.Context, id int, update laundryNotify.UserEventUpdate) (*laundryNotify.UserEvent, error) {
 	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
-		return nil, 0, err
+		return nil, err
 	}
 	defer tx.Rollback()
 
-	events, n, err := findUpcomingUserEvents(ctx, tx, eventType)
+	event, err := updateUserEvent(ctx, tx, id, update)
 	if err != nil {
-		return nil, 0, err
+		return nil, err
 	}
 
-	return events, n, nil
+	return event, tx.Commit()
 }
 
-func (s *UserEventService) CreateUserEvent(ctx context.Context, userEvent *laundryNotify.UserEvent) error {
+// DeleteUserEvent removes a subscription. Returns ENOTFOUND if it doesn't exist, or ECONFLICT if its
+// event has already finished.
+func (s *UserEventService) DeleteUserEvent(ctx context.Context, id int) error {
 	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
 	defer tx.Rollback()
 
-	if err := createUserEvent(ctx, tx, userEvent); err != nil {
+	if err := deleteUserEvent(ctx, tx, id); err != nil {
 		return err
 	}
 
 	return tx.Commit()
 }
 
-func (s *UserEventService) UpdateUserEvent(ctx context.Context, id int, update laundryNotify.UserEventUpdate) (*laundryNotify.UserEvent, error) {
+func (s *UserEventService) FindUserEvents(ctx context.Context, filter laundryNotify.UserEventFilter) ([]*laundryNotify.UserEvent, int, error) {
 	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 	defer tx.Rollback()
-
-	event, err := updateUserEvent(ctx, tx, id, update)
-	if err != nil {
-		return nil, err
-	}
-
-	return event, tx.Commit()
+	return findUserEvents(ctx, tx, filter)
 }
 
 func createUserEvent(ctx context.Context, tx *Tx, userEvent *laundryNotify.UserEvent) error {
@@ -114,115 +128,145 @@ func createUserEvent(ctx context.Context, tx *Tx, userEvent *laundryNotify.UserE
 		return err
 	}
 
-	_, err := tx.ExecContext(
+	res, err := tx.ExecContext(
 		ctx,
 		`
-		INSERT INTO user_events (user_id, event_id, created_at, type) 
-		VALUES (?, ?, ?, ?)
+		INSERT INTO user_events (user_id, event_id, created_at, type, follow_on, chained_from_id) 
+		VALUES (?, ?, ?, ?, ?, ?)
 		`,
-		userEvent.UserId, userEvent.EventId, userEvent.CreatedAt, userEvent.Type,
+		userEvent.UserId, userEvent.EventId, userEvent.CreatedAt, userEvent.Type, userEvent.FollowOn, userEvent.ChainedFromId,
 	)
-	return err
-}
-
-func findUserEventById(ctx context.Context, tx *Tx, id int) (*laundryNotify.UserEvent, error) {
-	a, _, err := findUserEvents(ctx, tx, laundryNotify.UserEventFilter{Id: &id})
 	if err != nil {
-		return nil, err
+		return err
 	}
-	if len(a) == 0 {
-		return nil, laundryNotify.Errorf(laundryNotify.ENOTFOUND, "UserEvent not found: %d", id)
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return err
 	}
-	return a[0], nil
+	userEvent.Id = int(id)
+
+	return nil
 }
 
-func findByUserName(ctx context.Context, tx *Tx, name string, eventType string) ([]*laundryNotify.UserEvent, int, error) {
-	rows, err := tx.QueryContext(ctx, `
-		SELECT
-			ue.id,
-			ue.user_id,
-			ue.event_id,
-			ue.created_at,
-			ue.type,
-			COUNT(*) OVER()
-		FROM user_events ue
-		JOIN users u ON u.id = ue.user_id
-		WHERE u.name = ?
-			AND ue.type = ?
-			AND (
-				ue.event_id IS NULL
-				OR ue.event_id = 0
-			)
-		ORDER BY ue.created_at DESC
-		`+FormatLimitOffset(5, 0),
-		name,
-		eventType,
+// subscribe finds the running event and registers the user for it in a single insert, so the event can't
+// start or finish in between. The insert takes the write lock, so the event is looked up again afterwards
+// to find out which one they were registered for
+func subscribe(ctx context.Context, tx *Tx, userEvent *laundryNotify.UserEvent) (*laundryNotify.Event, bool, error) {
+	userEvent.EventId = 0
+	userEvent.CreatedAt = sql.NullTime{Time: tx.now, Valid: true}
+	if err := userEvent.Validate(); err != nil {
+		return nil, false, err
+	}
+
+	res, err := tx.ExecContext(ctx, `
+		INSERT INTO user_events (user_id, event_id, created_at, type, follow_on, chained_from_id)
+		SELECT ?, running.id, ?, ?, ?, 0
+		FROM (
+			SELECT COALESCE((
+				SELECT CASE WHEN finished_at IS NULL THEN id ELSE 0 END
+				FROM events
+				WHERE type = ?
+				ORDER BY started_at DESC
+				LIMIT 1
+			), 0) AS id
+		) running
+		WHERE NOT EXISTS (
+			SELECT 1
+			FROM user_events existing
+			WHERE existing.user_id = ?
+				AND existing.type = ?
+				AND COALESCE(existing.event_id, 0) = running.id
+		)
+		`,
+		userEvent.UserId,
+		userEvent.CreatedAt,
+		userEvent.Type,
+		userEvent.FollowOn,
+		userEvent.Type,
+		userEvent.UserId,
+		userEvent.Type,
 	)
 	if err != nil {
-		return nil, 0, err
+		return nil, false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return nil, false, err
 	}
-	defer rows.Close()
 
-	var events []*laundryNotify.UserEvent
-	var n int
-	for rows.Next() {
-		var e laundryNotify.UserEvent
-		if err := rows.Scan(
-			&e.Id,
-			&e.UserId,
-			&e.EventId,
-			&e.CreatedAt,
-			&e.Type,
-			&n,
-		); err != nil {
-			return nil, 0, err
+	event, err := findMostRecentEvent(ctx, tx, userEvent.Type)
+	if err != nil {
+		return nil, false, err
+	}
+	eventId := 0
+	if event != nil && !event.FinishedAt.Valid {
+		eventId = event.Id
+	} else {
+		event = nil
+	}
+
+	if n > 0 {
+		id, err := res.LastInsertId()
+		if err != nil {
+			return nil, false, err
+		}
+		userEvent.Id = int(id)
+		userEvent.EventId = eventId
+		return event, false, nil
+	}
+
+	existing, _, err := findUserEvents(ctx, tx, laundryNotify.UserEventFilter{
+		UserId:  &userEvent.UserId,
+		EventId: &eventId,
+		Type:    &userEvent.Type,
+		Limit:   1,
+	})
+	if err != nil {
+		return nil, false, err
+	} else if len(existing) == 0 {
+		return nil, false, laundryNotify.Errorf(laundryNotify.EINTERNAL, "Existing user event not found.")
+	}
+
+	found := existing[0]
+	if userEvent.FollowOn != "" && found.FollowOn == "" {
+		if found, err = updateUserEvent(ctx, tx, found.Id, laundryNotify.UserEventUpdate{FollowOn: userEvent.FollowOn}); err != nil {
+			return nil, false, err
 		}
-		events = append(events, &e)
 	}
-	return events, n, nil
+	*userEvent = *found
+	return event, true, nil
 }
 
-func findUpcomingUserEvents(ctx context.Context, tx *Tx, eventType string) ([]*laundryNotify.UserEvent, int, error) {
-	rows, err := tx.QueryContext(ctx, `
-		SELECT
-			ue.id,
-			ue.user_id,
-			ue.event_id,
-			ue.created_at,
-			ue.type,
-			COUNT(*) OVER()
-		FROM user_events ue
-		WHERE ue.type = ?
-		AND (
-			ue.event_id IS NULL
-			OR ue.event_id = 0
-		)	
-		ORDER BY ue.created_at DESC
-		`+FormatLimitOffset(5, 0),
-		eventType,
-	)
+func deleteUserEvent(ctx context.Context, tx *Tx, id int) error {
+	userEvent, err := findUserEventById(ctx, tx, id)
 	if err != nil {
-		return nil, 0, err
+		return err
 	}
-	defer rows.Close()
 
-	var events []*laundryNotify.UserEvent
-	var n int
-	for rows.Next() {
-		var e laundryNotify.UserEvent
-		if err := rows.Scan(
-			&e.Id,
-			&e.UserId,
-			&e.EventId,
-			&e.CreatedAt,
-			&e.Type,
-			&n,
-		); err != nil {
-			return nil, 0, err
+	if userEvent.EventId > 0 {
+		event, err := findEventById(ctx, tx, userEvent.EventId)
+		if err != nil {
+			return err
+		}
+		if event.FinishedAt.Valid {
+			return laundryNotify.Errorf(laundryNotify.ECONFLICT, "Event has already finished.")
 		}
-		events = append(events, &e)
 	}
-	return events, n, nil
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM user_events WHERE id = ?`, id)
+	return err
+}
+
+func findUserEventById(ctx context.Context, tx *Tx, id int) (*laundryNotify.UserEvent, error) {
+	a, _, err := findUserEvents(ctx, tx, laundryNotify.UserEventFilter{Id: &id})
+	if err != nil {
+		return nil, err
+	}
+	if len(a) == 0 {
+		return nil, laundryNotify.Errorf(laundryNotify.ENOTFOUND, "UserEvent not found: %d", id)
+	}
+	return a[0], nil
 }
 
 func findUserNamesEventByEventId(ctx context.Context, tx *Tx, eventId int) ([]string, error) {
@@ -253,31 +297,133 @@ func findUserNamesEventByEventId(ctx context.Context, tx *Tx, eventId int) ([]st
 	return name, nil
 }
 
+func findUserIdsByEventId(ctx context.Context, tx *Tx, eventId int) ([]int, error) {
+	rows, err := tx.QueryContext(ctx, `
+		SELECT user_id
+		FROM user_events
+		WHERE event_id = ?
+		ORDER BY id
+		`, eventId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return ids, nil
+}
+
+// attachUpcomingUserEvents attaches every pending user event for the event's type to it in a single update,
+// so registrations made while it runs either make it in or stay pending for the next event. Users already
+// subscribed to the event are left pending. Returns the user events attached
+func attachUpcomingUserEvents(ctx context.Context, tx *Tx, eventId int) ([]*laundryNotify.UserEvent, error) {
+	event, err := findEventById(ctx, tx, eventId)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE user_events
+		SET event_id = ?
+		WHERE type = ?
+			AND COALESCE(event_id, 0) = 0
+			AND NOT EXISTS (
+				SELECT 1
+				FROM user_events attached
+				WHERE attached.user_id = user_events.user_id
+					AND attached.event_id = ?
+					AND attached.type = user_events.type
+			)
+		`,
+		event.Id,
+		event.Type,
+		event.Id,
+	); err != nil {
+		return nil, err
+	}
+
+	userEvents, _, err := findUserEvents(ctx, tx, laundryNotify.UserEventFilter{EventId: &event.Id})
+	return userEvents, err
+}
+
+// chainUserEvents subscribes users following their load on from the event to the next load of the appliance
+// they are following on to, unless they're already waiting for it. Returns how many were subscribed
+func chainUserEvents(ctx context.Context, tx *Tx, eventId int) (int, error) {
+	res, err := tx.ExecContext(ctx, `
+		INSERT INTO user_events (user_id, event_id, created_at, type, chained_from_id)
+		SELECT ue.user_id, 0, ?, ue.follow_on, ue.id
+		FROM user_events ue
+		WHERE ue.event_id = ?
+			AND ue.follow_on != ''
+			AND NOT EXISTS (
+				SELECT 1
+				FROM user_events pending
+				WHERE pending.user_id = ue.user_id
+					AND pending.type = ue.follow_on
+					AND COALESCE(pending.event_id, 0) = 0
+			)
+		`,
+		sql.NullTime{Time: tx.now, Valid: true},
+		eventId,
+	)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
 func findUserEvents(ctx context.Context, tx *Tx, filter laundryNotify.UserEventFilter) (_ []*laundryNotify.UserEvent, n int, err error) {
 	// Build WHERE clause
 	where, args := []string{"1 = 1"}, []interface{}{}
 	if v := filter.Id; v != nil {
-		where, args = append(where, "id = ?"), append(args, *v)
+		where, args = append(where, "ue.id = ?"), append(args, *v)
+	}
+	if v := filter.UserId; v != nil {
+		where, args = append(where, "ue.user_id = ?"), append(args, *v)
+	}
+	if v := filter.EventId; v != nil {
+		where, args = append(where, "COALESCE(ue.event_id, 0) = ?"), append(args, *v)
+	}
+	if v := filter.Type; v != nil {
+		where, args = append(where, "ue.type = ?"), append(args, *v)
+	}
+	if filter.Active {
+		where = append(where, "(COALESCE(ue.event_id, 0) = 0 OR e.finished_at IS NULL)")
 	}
 
 	rows, err := tx.QueryContext(ctx, `
 		SELECT 
-			id,
-			user_id,
-			event_id,
-			created_at,
-			type,
+			ue.id,
+			ue.user_id,
+			COALESCE(ue.event_id, 0),
+			ue.created_at,
+			ue.type,
+			ue.follow_on,
+			COALESCE(ue.chained_from_id, 0),
 			COUNT(*) OVER()
-		FROM user_events
+		FROM user_events ue
+		LEFT JOIN events e ON e.id = ue.event_id
 		WHERE `+strings.Join(where, " AND ")+`
-		ORDER BY created_at DESC
-		`+FormatLimitOffset(1, 0), args...)
+		ORDER BY ue.created_at DESC, ue.id DESC
+		`+FormatLimitOffset(filter.Limit, filter.Offset), args...)
 	if err != nil {
 		return nil, 0, err
 	}
 	defer rows.Close()
 
-	var a []*laundryNotify.UserEvent
+	a := make([]*laundryNotify.UserEvent, 0)
 	for rows.Next() {
 		var ue laundryNotify.UserEvent
 		if err := rows.Scan(
@@ -286,13 +432,18 @@ func findUserEvents(ctx context.Context, tx *Tx, filter laundryNotify.UserEventF
 			&ue.EventId,
 			&ue.CreatedAt,
 			&ue.Type,
+			&ue.FollowOn,
+			&ue.ChainedFromId,
 			&n,
 		); err != nil {
 			return nil, 0, err
 		}
 		a = append(a, &ue)
 	}
-	return a, len(a), nil
+	if err = rows.Err(); err != nil {
+		return nil, 0, err
+	}
+	return a, n, nil
 }
 
 func updateUserEvent(ctx context.Context, tx *Tx, id int, update laundryNotify.UserEventUpdate) (*laundryNotify.UserEvent, error) {
@@ -304,6 +455,9 @@ func updateUserEvent(ctx context.Context, tx *Tx, id int, update laundryNotify.U
 	if v := update.EventId; v > 0 {
 		userEvent.EventId = v
 	}
+	if v := update.FollowOn; v != "" {
+		userEvent.FollowOn = v
+	}
 
 	if err = userEvent.Validate(); err != nil {
 		return nil, err
@@ -313,10 +467,11 @@ func updateUserEvent(ctx context.Context, tx *Tx, id int, update laundryNotify.U
 		ctx,
 		`
 		UPDATE user_events
-		SET event_id = ?
+		SET event_id = ?, follow_on = ?
 		WHERE id = ?
 		`,
 		userEvent.EventId,
+		userEvent.FollowOn,
 		id,
 	)
 	if err != nil {
@@ -0,0 +1,171 @@
+package sqlite
+
+import (
+	"context"
+	"fmt"
+	laundryNotify "jallier/laundry-notify"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestEventService_StartEvent_AttachesUpcomingUserEvents(t *testing.T) {
+	ctx := context.Background()
+	db := MustOpenDB(t)
+	users, userEvents, events := NewUserService(db), NewUserEventService(db), NewEventService(db)
+
+	// Registers a new user for the next load of the type
+	register := func(name string, eventType string) *laundryNotify.UserEvent {
+		user := &laundryNotify.User{Name: name}
+		if err := users.CreateUser(ctx, user); err != nil {
+			t.Error(err)
+			return nil
+		}
+		userEvent := &laundryNotify.UserEvent{UserId: user.Id, Type: eventType}
+		if err := userEvents.CreateUserEvent(ctx, userEvent); err != nil {
+			t.Error(err)
+			return nil
+		}
+		return userEvent
+	}
+
+	// More than the five that used to be attached
+	washers := make([]*laundryNotify.UserEvent, 0)
+	for i := 0; i < 10; i++ {
+		washers = append(washers, register(fmt.Sprintf("washer%d", i), "washer"))
+	}
+	dryers := []*laundryNotify.UserEvent{register("dryer0", "dryer"), register("dryer1", "dryer")}
+	if t.Failed() {
+		t.FailNow()
+	}
+
+	event, attached, err := events.StartEvent(ctx, "washer", time.Now().UTC().Truncate(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	} else if got, want := len(attached), len(washers); got != want {
+		t.Fatalf("attached=%d, want %d", got, want)
+	}
+
+	for _, want := range washers {
+		if userEvent, err := userEvents.FindUserEventById(ctx, want.Id); err != nil {
+			t.Fatal(err)
+		} else if userEvent.EventId != event.Id {
+			t.Errorf("user event %d: EventId=%d, want %d", want.Id, userEvent.EventId, event.Id)
+		}
+	}
+
+	for _, want := range dryers {
+		if userEvent, err := userEvents.FindUserEventById(ctx, want.Id); err != nil {
+			t.Fatal(err)
+		} else if userEvent.EventId != 0 {
+			t.Errorf("dryer user event %d: EventId=%d, want 0", want.Id, userEvent.EventId)
+		}
+	}
+}
+
+func TestEventService_StartEvent_ConcurrentRegistrations(t *testing.T) {
+	ctx := context.Background()
+	db := MustOpenFileDB(t)
+	users, userEvents, events := NewUserService(db), NewUserEventService(db), NewEventService(db)
+
+	washers := make([]*laundryNotify.User, 20)
+	for i := range washers {
+		washers[i] = &laundryNotify.User{Name: fmt.Sprintf("washer%d", i)}
+		if err := users.CreateUser(ctx, washers[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	dryer := &laundryNotify.User{Name: "dryer"}
+	if err := users.CreateUser(ctx, dryer); err != nil {
+		t.Fatal(err)
+	}
+	pendingDryer := &laundryNotify.UserEvent{UserId: dryer.Id, Type: "dryer"}
+	if _, _, err := userEvents.Subscribe(ctx, pendingDryer); err != nil {
+		t.Fatal(err)
+	}
+
+	// Everyone registers at the same time as the load starts, each on their own connection. Whether they
+	// register before or after it starts, they must end up on it
+	start := make(chan struct{})
+	var wg sync.WaitGroup
+	registered := make([]*laundryNotify.UserEvent, len(washers))
+	for i, user := range washers {
+		wg.Add(1)
+		go func(i int, user *laundryNotify.User) {
+			defer wg.Done()
+			<-start
+			registered[i] = &laundryNotify.UserEvent{UserId: user.Id, Type: "washer"}
+			if _, _, err := userEvents.Subscribe(ctx, registered[i]); err != nil {
+				t.Error(err)
+			}
+		}(i, user)
+	}
+	var event *laundryNotify.Event
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		<-start
+		var err error
+		if event, _, err = events.StartEvent(ctx, "washer", time.Now().UTC().Truncate(time.Second)); err != nil {
+			t.Error(err)
+		}
+	}()
+	close(start)
+	wg.Wait()
+	if t.Failed() {
+		t.FailNow()
+	}
+
+	for _, want := range registered {
+		if userEvent, err := userEvents.FindUserEventById(ctx, want.Id); err != nil {
+			t.Fatal(err)
+		} else if userEvent.EventId != event.Id {
+			t.Errorf("user event %d: EventId=%d, want %d", want.Id, userEvent.EventId, event.Id)
+		}
+	}
+	if userEvent, err := userEvents.FindUserEventById(ctx, pendingDryer.Id); err != nil {
+		t.Fatal(err)
+	} else if userEvent.EventId != 0 {
+		t.Errorf("dryer user event: EventId=%d, want 0", userEvent.EventId)
+	}
+}
+
+func TestUserEventService_Subscribe(t *testing.T) {
+	ctx := context.Background()
+	db := MustOpenDB(t)
+	users, userEvents, events := NewUserService(db), NewUserEventService(db), NewEventService(db)
+
+	user := &laundryNotify.User{Name: "user"}
+	if err := users.CreateUser(ctx, user); err != nil {
+		t.Fatal(err)
+	}
+
+	// Nothing is running, so they wait for the next load
+	pending := &laundryNotify.UserEvent{UserId: user.Id, Type: "washer"}
+	if event, existing, err := userEvents.Subscribe(ctx, pending); err != nil {
+		t.Fatal(err)
+	} else if event != nil || existing || pending.Id == 0 || pending.EventId != 0 {
+		t.Fatalf("event=%v existing=%v user event=%+v, want a new pending user event", event, existing, pending)
+	}
+
+	// Registering again returns the same user event, following the load on if asked
+	again := &laundryNotify.UserEvent{UserId: user.Id, Type: "washer", FollowOn: "dryer"}
+	if _, existing, err := userEvents.Subscribe(ctx, again); err != nil {
+		t.Fatal(err)
+	} else if !existing || again.Id != pending.Id || again.FollowOn != "dryer" {
+		t.Fatalf("existing=%v user event=%+v, want user event %d following on", existing, again, pending.Id)
+	}
+
+	running, _, err := events.StartEvent(ctx, "washer", time.Now().UTC().Truncate(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The pending user event was attached, so registering now finds it on the running event
+	current := &laundryNotify.UserEvent{UserId: user.Id, Type: "washer"}
+	if event, existing, err := userEvents.Subscribe(ctx, current); err != nil {
+		t.Fatal(err)
+	} else if event == nil || event.Id != running.Id || !existing || current.Id != pending.Id || current.EventId != running.Id {
+		t.Fatalf("event=%v existing=%v user event=%+v, want user event %d on event %d", event, existing, current, pending.Id, running.Id)
+	}
+}
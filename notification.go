@@ -0,0 +1,77 @@
+package laundryNotify
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+const NOTIFICATION_PENDING = "pending"
+const NOTIFICATION_DELIVERED = "delivered"
+const NOTIFICATION_FAILED = "failed"
+
+// Notification is a message queued in the outbox for delivery to a user
+type Notification struct {
+	Id int
+	// The user to notify. Zero if the notification is sent straight to Topic
+	UserId  int
+	EventId int
+	// Explicit topic to send to. If empty, the topic is derived from the user
+	Topic         string
+	Title         string
+	Message       string
+	Status        string
+	Attempts      int
+	LastError     string
+	NextAttemptAt sql.NullTime
+	CreatedAt     sql.NullTime
+	DeliveredAt   sql.NullTime
+}
+
+func (n *Notification) Validate() error {
+	if n.UserId <= 0 && n.Topic == "" {
+		return Errorf(EINVALID, "Notification user or topic required.")
+	}
+
+	if n.Title == "" {
+		return Errorf(EINVALID, "Notification title required.")
+	}
+
+	switch n.Status {
+	case NOTIFICATION_PENDING, NOTIFICATION_DELIVERED, NOTIFICATION_FAILED:
+	default:
+		return Errorf(EINVALID, "Invalid notification status: %s", n.Status)
+	}
+
+	if !n.CreatedAt.Valid {
+		return Errorf(EINVALID, "Notification creation time required.")
+	}
+
+	return nil
+}
+
+// NotificationUpdate represents a set of fields to update on a notification
+type NotificationUpdate struct {
+	Status        *string
+	Attempts      *int
+	LastError     *string
+	NextAttemptAt sql.NullTime
+	DeliveredAt   sql.NullTime
+}
+
+type NotificationFilter struct {
+	Id      *int
+	UserId  *int
+	EventId *int
+	Status  *string
+	// Only notifications due for an attempt at or before this time
+	DueBy  time.Time
+	Limit  int
+	Offset int
+}
+
+type NotificationService interface {
+	FindNotifications(ctx context.Context, filter NotificationFilter) ([]*Notification, int, error)
+	CreateNotification(ctx context.Context, notification *Notification) error
+	UpdateNotification(ctx context.Context, id int, update NotificationUpdate) (*Notification, error)
+}
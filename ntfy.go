@@ -1,5 +1,23 @@
 package laundryNotify
 
+import "context"
+
+const PRIORITY_LOW = "low"
+const PRIORITY_DEFAULT = "default"
+const PRIORITY_HIGH = "high"
+
 type LaundryNotifyService interface {
-	Notify(topic string, title string, message string) error
+	// Notify sends the notification, giving up once the context is done
+	Notify(ctx context.Context, topic string, title string, message string) error
+}
+
+// NotifyOptions are hints for how a notification is sent. Backends ignore any they don't support
+type NotifyOptions struct {
+	Priority string
+	Language string
+}
+
+// OptionsNotifyService is implemented by notifier backends that can make use of NotifyOptions
+type OptionsNotifyService interface {
+	NotifyWithOptions(ctx context.Context, topic string, title string, message string, options NotifyOptions) error
 }
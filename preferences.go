@@ -0,0 +1,127 @@
+package laundryNotify
+
+import (
+	"context"
+	"database/sql"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Format of quiet hours, in local time
+const QUIET_HOURS_FORMAT = "15:04"
+
+// Preferences are how a user wants to be notified. Users that haven't saved any get the zero value,
+// which sends to a topic derived from their name on the default notifier.
+type Preferences struct {
+	UserId int
+	// Notifier backend to use, eg ntfy or email. Empty uses the default backend
+	Channel string
+	// Where to send on the channel. The ntfy topic suffix, an email address or a webhook url. Empty
+	// derives a topic from the user's name
+	Target string
+	// Notifications that come due between these times are held until quiet hours end. Empty disables them
+	QuietStart string
+	QuietEnd   string
+	// One of the PRIORITY_ constants. Empty uses the backend's default
+	Priority string
+	// Language code passed on to backends that support it, eg en
+	Language  string
+	UpdatedAt sql.NullTime
+}
+
+func (p *Preferences) Validate() error {
+	if p.UserId <= 0 {
+		return Errorf(EINVALID, "Preferences user required.")
+	}
+
+	switch p.Channel {
+	case "email":
+		if p.Target != "" && !strings.Contains(p.Target, "@") {
+			return Errorf(EINVALID, "Email target must be an email address.")
+		}
+	case "webhook":
+		if p.Target != "" {
+			if u, err := url.Parse(p.Target); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return Errorf(EINVALID, "Webhook target must be an http or https url.")
+			}
+		}
+	}
+	if strings.ContainsAny(p.Target, "\r\n") {
+		return Errorf(EINVALID, "Invalid target.")
+	}
+
+	if (p.QuietStart == "") != (p.QuietEnd == "") {
+		return Errorf(EINVALID, "Quiet hours need both a start and end.")
+	}
+	for _, v := range []string{p.QuietStart, p.QuietEnd} {
+		if _, err := time.Parse(QUIET_HOURS_FORMAT, v); v != "" && err != nil {
+			return Errorf(EINVALID, "Quiet hours must be formatted as HH:MM: %s", v)
+		}
+	}
+
+	switch p.Priority {
+	case "", PRIORITY_LOW, PRIORITY_DEFAULT, PRIORITY_HIGH:
+	default:
+		return Errorf(EINVALID, "Invalid priority: %s", p.Priority)
+	}
+
+	if len(p.Language) > 16 || strings.ContainsAny(p.Language, " \r\n") {
+		return Errorf(EINVALID, "Invalid language: %s", p.Language)
+	}
+
+	return nil
+}
+
+// QuietUntil returns when quiet hours end, if the time falls within them
+func (p *Preferences) QuietUntil(t time.Time) (time.Time, bool) {
+	start, err := time.Parse(QUIET_HOURS_FORMAT, p.QuietStart)
+	if err != nil {
+		return time.Time{}, false
+	}
+	end, err := time.Parse(QUIET_HOURS_FORMAT, p.QuietEnd)
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	t = t.Local()
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.Local)
+	since := t.Sub(midnight)
+	startAt := time.Duration(start.Hour())*time.Hour + time.Duration(start.Minute())*time.Minute
+	endAt := time.Duration(end.Hour())*time.Hour + time.Duration(end.Minute())*time.Minute
+
+	switch {
+	case startAt == endAt:
+		return time.Time{}, false
+	case startAt < endAt && since >= startAt && since < endAt:
+		return midnight.Add(endAt), true
+	case startAt > endAt && since >= startAt:
+		// Quiet hours run past midnight, so they end tomorrow
+		return midnight.AddDate(0, 0, 1).Add(endAt), true
+	case startAt > endAt && since < endAt:
+		return midnight.Add(endAt), true
+	}
+	return time.Time{}, false
+}
+
+// WebhookTargetAllowed reports whether a webhook url set by a user is on one of the allowed hosts. Users
+// can't pick any url, as the server would post to it on their behalf, including to internal addresses
+func WebhookTargetAllowed(target string, allowedHosts []string) bool {
+	u, err := url.Parse(target)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.User != nil {
+		return false
+	}
+	for _, host := range allowedHosts {
+		if host != "" && strings.EqualFold(u.Hostname(), host) {
+			return true
+		}
+	}
+	return false
+}
+
+type PreferencesService interface {
+	// FindPreferences returns the user's preferences, or the defaults if they haven't saved any
+	FindPreferences(ctx context.Context, userId int) (*Preferences, error)
+	// SavePreferences creates or replaces the user's preferences
+	SavePreferences(ctx context.Context, preferences *Preferences) error
+}
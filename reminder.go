@@ -0,0 +1,63 @@
+package laundryNotify
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// Reminder is a follow up notification for a user whose laundry hasn't been collected yet
+type Reminder struct {
+	Id       int
+	UserId   int
+	EventId  int
+	Type     string
+	RemindAt sql.NullTime
+	// Set once the reminder has been queued for delivery
+	SentAt sql.NullTime
+	// Set if the reminder is no longer needed, eg the user collected their laundry
+	CancelledAt sql.NullTime
+	CreatedAt   sql.NullTime
+}
+
+func (r *Reminder) Validate() error {
+	if r.UserId <= 0 {
+		return Errorf(EINVALID, "User ID required.")
+	}
+
+	if r.EventId <= 0 {
+		return Errorf(EINVALID, "Event ID required.")
+	}
+
+	if r.Type == "" {
+		return Errorf(EINVALID, "Reminder type required.")
+	}
+
+	if !r.RemindAt.Valid {
+		return Errorf(EINVALID, "Reminder time required.")
+	}
+
+	return nil
+}
+
+type ReminderFilter struct {
+	Id      *int
+	UserId  *int
+	EventId *int
+	// Only reminders that haven't been sent or cancelled
+	Pending bool
+	// Only reminders due at or before this time
+	DueBy  time.Time
+	Limit  int
+	Offset int
+}
+
+type ReminderService interface {
+	FindReminders(ctx context.Context, filter ReminderFilter) ([]*Reminder, int, error)
+	CreateReminders(ctx context.Context, reminders []*Reminder) error
+	// SendReminder queues the notification and marks the reminder as sent, in a single transaction
+	SendReminder(ctx context.Context, id int, notification Notification) error
+	CancelReminder(ctx context.Context, id int) error
+	// AcknowledgeReminders cancels the user's pending reminders for an event
+	AcknowledgeReminders(ctx context.Context, userId int, eventId int) (int, error)
+}
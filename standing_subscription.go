@@ -0,0 +1,147 @@
+package laundryNotify
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// Which cycles a standing subscription is materialised for
+const SCHEDULE_ALL = "all"
+const SCHEDULE_WINDOW = "window"
+const SCHEDULE_COUNT = "count"
+
+// Format of a schedule window's start and end, in local time
+const SCHEDULE_WINDOW_FORMAT = "15:04"
+
+// StandingSubscription subscribes a user to every cycle of an appliance that matches its schedule until it
+// expires. Each matching cycle gets its own UserEvent when it starts, so it's notified like any other.
+type StandingSubscription struct {
+	Id     int
+	UserId int
+	Type   string
+	// One of the SCHEDULE_ constants
+	Schedule string
+	// Days a SCHEDULE_WINDOW subscription applies. Empty means every day
+	Weekdays []time.Weekday
+	// Cycles starting between these times are matched by a SCHEDULE_WINDOW subscription. They're in the
+	// server's local time, set with TZ, as are the weekdays. The window may run past midnight, in which case
+	// it belongs to the day it started
+	WindowStart string
+	WindowEnd   string
+	// Cycles left for a SCHEDULE_COUNT subscription. It stops matching once this reaches zero
+	Remaining int
+	ExpiresAt sql.NullTime
+	CreatedAt sql.NullTime
+}
+
+func (s *StandingSubscription) Validate() error {
+	if s.UserId <= 0 {
+		return Errorf(EINVALID, "Standing subscription user required.")
+	}
+
+	if s.Type == "" {
+		return Errorf(EINVALID, "Standing subscription type required.")
+	}
+
+	if !s.ExpiresAt.Valid {
+		return Errorf(EINVALID, "Standing subscription expiry required.")
+	}
+
+	switch s.Schedule {
+	case SCHEDULE_ALL:
+	case SCHEDULE_WINDOW:
+		for _, v := range []string{s.WindowStart, s.WindowEnd} {
+			if _, err := time.Parse(SCHEDULE_WINDOW_FORMAT, v); err != nil {
+				return Errorf(EINVALID, "Schedule window must be formatted as HH:MM: %s", v)
+			}
+		}
+		if s.WindowStart == s.WindowEnd {
+			return Errorf(EINVALID, "Schedule window start and end must be different.")
+		}
+		for _, day := range s.Weekdays {
+			if day < time.Sunday || day > time.Saturday {
+				return Errorf(EINVALID, "Invalid weekday: %d", day)
+			}
+		}
+	case SCHEDULE_COUNT:
+		if s.Remaining <= 0 {
+			return Errorf(EINVALID, "Number of cycles must be at least 1.")
+		}
+	default:
+		return Errorf(EINVALID, "Invalid schedule: %s", s.Schedule)
+	}
+
+	return nil
+}
+
+// Active reports whether the subscription can still match a cycle starting at the time
+func (s *StandingSubscription) Active(t time.Time) bool {
+	if !s.ExpiresAt.Valid || !t.Before(s.ExpiresAt.Time) {
+		return false
+	}
+	return s.Schedule != SCHEDULE_COUNT || s.Remaining > 0
+}
+
+// Matches reports whether a cycle starting at the time should be subscribed to
+func (s *StandingSubscription) Matches(startedAt time.Time) bool {
+	if !s.Active(startedAt) {
+		return false
+	}
+	if s.Schedule != SCHEDULE_WINDOW {
+		return true
+	}
+
+	start, err := time.Parse(SCHEDULE_WINDOW_FORMAT, s.WindowStart)
+	if err != nil {
+		return false
+	}
+	end, err := time.Parse(SCHEDULE_WINDOW_FORMAT, s.WindowEnd)
+	if err != nil {
+		return false
+	}
+
+	t := startedAt.Local()
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.Local)
+	since := t.Sub(midnight)
+	startAt := time.Duration(start.Hour())*time.Hour + time.Duration(start.Minute())*time.Minute
+	endAt := time.Duration(end.Hour())*time.Hour + time.Duration(end.Minute())*time.Minute
+
+	day := t.Weekday()
+	switch {
+	case startAt < endAt && since >= startAt && since < endAt:
+	case startAt > endAt && since >= startAt:
+	case startAt > endAt && since < endAt:
+		// The window started yesterday and runs past midnight
+		day = (day + 6) % 7
+	default:
+		return false
+	}
+
+	if len(s.Weekdays) == 0 {
+		return true
+	}
+	for _, v := range s.Weekdays {
+		if v == day {
+			return true
+		}
+	}
+	return false
+}
+
+type StandingSubscriptionFilter struct {
+	Id     *int
+	UserId *int
+	Type   *string
+	// Only return subscriptions that haven't expired or run out of cycles
+	Active bool
+
+	Offset int
+	Limit  int
+}
+
+type StandingSubscriptionService interface {
+	FindStandingSubscriptions(ctx context.Context, filter StandingSubscriptionFilter) ([]*StandingSubscription, int, error)
+	CreateStandingSubscription(ctx context.Context, subscription *StandingSubscription) error
+	DeleteStandingSubscription(ctx context.Context, id int) error
+}
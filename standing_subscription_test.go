@@ -0,0 +1,66 @@
+package laundryNotify
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+)
+
+func TestStandingSubscription_Matches(t *testing.T) {
+	// Windows are in local time, so use a zone that's a different day to UTC for part of it
+	local := time.Local
+	time.Local = time.FixedZone("UTC+10", 10*60*60)
+	t.Cleanup(func() { time.Local = local })
+
+	// Monday 1 January 2024, local time
+	at := func(day int, hour int, minute int) time.Time {
+		return time.Date(2024, 1, day, hour, minute, 0, 0, time.Local)
+	}
+	expiresAt := sql.NullTime{Time: at(31, 0, 0), Valid: true}
+	morning := func(weekdays ...time.Weekday) *StandingSubscription {
+		return &StandingSubscription{Schedule: SCHEDULE_WINDOW, Weekdays: weekdays, WindowStart: "07:00", WindowEnd: "09:00", ExpiresAt: expiresAt}
+	}
+	overnight := func(weekdays ...time.Weekday) *StandingSubscription {
+		return &StandingSubscription{Schedule: SCHEDULE_WINDOW, Weekdays: weekdays, WindowStart: "22:00", WindowEnd: "02:00", ExpiresAt: expiresAt}
+	}
+
+	tests := []struct {
+		name         string
+		subscription *StandingSubscription
+		startedAt    time.Time
+		want         bool
+	}{
+		{name: "all", subscription: &StandingSubscription{Schedule: SCHEDULE_ALL, ExpiresAt: expiresAt}, startedAt: at(1, 3, 0), want: true},
+		{name: "expired", subscription: &StandingSubscription{Schedule: SCHEDULE_ALL, ExpiresAt: expiresAt}, startedAt: at(31, 0, 0), want: false},
+		{name: "count remaining", subscription: &StandingSubscription{Schedule: SCHEDULE_COUNT, Remaining: 1, ExpiresAt: expiresAt}, startedAt: at(1, 3, 0), want: true},
+		{name: "count used up", subscription: &StandingSubscription{Schedule: SCHEDULE_COUNT, Remaining: 0, ExpiresAt: expiresAt}, startedAt: at(1, 3, 0), want: false},
+
+		{name: "in window", subscription: morning(), startedAt: at(1, 8, 0), want: true},
+		{name: "window start is inclusive", subscription: morning(), startedAt: at(1, 7, 0), want: true},
+		{name: "window end is exclusive", subscription: morning(), startedAt: at(1, 9, 0), want: false},
+		{name: "before window", subscription: morning(), startedAt: at(1, 6, 59), want: false},
+		{name: "in window in utc", subscription: morning(), startedAt: at(1, 8, 0).UTC(), want: true},
+
+		{name: "weekday matches", subscription: morning(time.Monday, time.Wednesday), startedAt: at(3, 8, 0), want: true},
+		{name: "weekday doesn't match", subscription: morning(time.Monday, time.Wednesday), startedAt: at(2, 8, 0), want: false},
+		// 8am Monday local is still Sunday in UTC
+		{name: "weekday is local", subscription: morning(time.Monday), startedAt: at(1, 8, 0).UTC(), want: true},
+
+		{name: "overnight before midnight", subscription: overnight(), startedAt: at(1, 23, 0), want: true},
+		{name: "overnight after midnight", subscription: overnight(), startedAt: at(2, 1, 0), want: true},
+		{name: "overnight outside window", subscription: overnight(), startedAt: at(2, 12, 0), want: false},
+		{name: "overnight end is exclusive", subscription: overnight(), startedAt: at(2, 2, 0), want: false},
+		// The window after midnight on Tuesday started on Monday
+		{name: "overnight belongs to the day it started", subscription: overnight(time.Monday), startedAt: at(2, 1, 0), want: true},
+		{name: "overnight not the next day", subscription: overnight(time.Tuesday), startedAt: at(2, 1, 0), want: false},
+		{name: "overnight before midnight on the day", subscription: overnight(time.Tuesday), startedAt: at(2, 23, 0), want: true},
+		{name: "overnight wraps the week", subscription: overnight(time.Saturday), startedAt: at(7, 1, 0), want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.subscription.Matches(tt.startedAt); got != tt.want {
+				t.Errorf("Matches(%s) = %v, want %v", tt.startedAt, got, tt.want)
+			}
+		})
+	}
+}
@@ -9,6 +9,8 @@ type User struct {
 	Id        int
 	Name      string
 	CreatedAt sql.NullTime
+	// Whether the user has set a PIN, so must sign in to manage their subscriptions
+	Protected bool
 }
 
 func (u *User) Validate() error {
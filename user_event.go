@@ -10,6 +10,11 @@ type UserEvent struct {
 	EventId   int
 	CreatedAt sql.NullTime
 	Type      string
+	// Type of appliance the user is subscribed to once this event finishes, eg the dryer after the washer.
+	// Empty if they aren't following their load
+	FollowOn string
+	// The subscription this one was created from when the user's load moved on. Zero if they registered for it
+	ChainedFromId int
 }
 
 func (u *UserEvent) Validate() error {
@@ -30,4 +35,6 @@ func (u *UserEvent) Validate() error {
 
 type UserEventUpdate struct {
 	EventId int
+	// Sets the type of appliance to follow the load on to, if not empty
+	FollowOn string
 }